@@ -0,0 +1,77 @@
+package options
+
+import (
+	"github.com/spf13/pflag"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+)
+
+// policyFlagNames are the flags overriding the policy of the configuration file.
+var policyFlagNames = []string{
+	"default-fallback-tier",
+	"default-not-ready-toleration-seconds",
+	"default-unreachable-toleration-seconds",
+	"spot-not-ready-toleration-seconds",
+	"spot-unreachable-toleration-seconds",
+}
+
+// ApplyConfiguration sets the options from the settings of c whose flag was
+// not set on flags, since flags take precedence over the configuration file.
+func (o *Options) ApplyConfiguration(c *config.WebhookConfiguration, flags *pflag.FlagSet) {
+	o.configuration = c
+	o.policyFlags = map[string]bool{}
+	for _, name := range policyFlagNames {
+		o.policyFlags[name] = flags.Changed(name)
+	}
+
+	apply := func(name string, set bool, fn func()) {
+		if set && !flags.Changed(name) {
+			fn()
+		}
+	}
+	apply("max-concurrent-decisions", c.MaxConcurrentDecisions != nil, func() { o.MaxConcurrentDecisions = *c.MaxConcurrentDecisions })
+	apply("decision-budget", c.DecisionBudget != nil, func() { o.DecisionBudget = c.DecisionBudget.Duration })
+	apply("counts-staleness", c.CountsStaleness != nil, func() { o.CountsStaleness = c.CountsStaleness.Duration })
+	apply("decision-cache-size", c.DecisionCacheSize != nil, func() { o.DecisionCacheSize = *c.DecisionCacheSize })
+	apply("decision-cache-ttl", c.DecisionCacheTTL != nil, func() { o.DecisionCacheTTL = c.DecisionCacheTTL.Duration })
+	o.DefaultFallbackTier = o.Policy(c).FallbackTier
+}
+
+// Configuration returns the configuration file applied by ApplyConfiguration,
+// nil without one.
+func (o *Options) Configuration() *config.WebhookConfiguration {
+	return o.configuration
+}
+
+// Policy returns the policy of c overridden by the policy flags set on the
+// command line, or the default policy with the flags if c is nil.
+func (o *Options) Policy(c *config.WebhookConfiguration) config.Policy {
+	p := config.DefaultPolicy()
+	if c == nil {
+		p.FallbackTier = o.DefaultFallbackTier
+	} else {
+		p.FallbackTier = c.Policy.FallbackTier
+		for name, tier := range c.Policy.Tiers {
+			p.Tiers[name] = tier
+		}
+	}
+	if o.policyFlags["default-fallback-tier"] {
+		p.FallbackTier = o.DefaultFallbackTier
+	}
+
+	override := func(tier string, notReadyFlag string, notReady int64, unreachableFlag string, unreachable int64) {
+		spec := p.Tiers[tier]
+		if spec.NotReadyTolerationSeconds == nil || o.policyFlags[notReadyFlag] {
+			spec.NotReadyTolerationSeconds = &notReady
+		}
+		if spec.UnreachableTolerationSeconds == nil || o.policyFlags[unreachableFlag] {
+			spec.UnreachableTolerationSeconds = &unreachable
+		}
+		p.Tiers[tier] = spec
+	}
+	override(config.TierOnDemand, "default-not-ready-toleration-seconds", o.DefaultNotReadyTolerationSeconds,
+		"default-unreachable-toleration-seconds", o.DefaultUnreachableTolerationSeconds)
+	override(config.TierSpot, "spot-not-ready-toleration-seconds", o.SpotNotReadyTolerationSeconds,
+		"spot-unreachable-toleration-seconds", o.SpotUnreachableTolerationSeconds)
+	return p
+}
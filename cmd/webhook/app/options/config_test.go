@@ -0,0 +1,71 @@
+package options
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+)
+
+func TestOptions_ApplyConfiguration(t *testing.T) {
+	c, err := config.Parse([]byte(`apiVersion: config.webhook-demo.com/v1alpha1
+kind: WebhookConfiguration
+decisionBudget: 1s
+decisionCacheSize: 10
+policy:
+  fallbackTier: on-demand
+  tiers:
+    spot:
+      deletionCost: 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOptions()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String(clientcmd.RecommendedConfigPathFlag, "", "")
+	o.AddFlags(flags)
+	if err := flags.Parse([]string{"--decision-cache-size=20", "--default-fallback-tier=spot"}); err != nil {
+		t.Fatal(err)
+	}
+	o.ApplyConfiguration(c, flags)
+
+	if o.DecisionBudget != time.Second {
+		t.Errorf("DecisionBudget = %v, want 1s from the file", o.DecisionBudget)
+	}
+	if o.DecisionCacheSize != 20 {
+		t.Errorf("DecisionCacheSize = %d, want 20 from the flag", o.DecisionCacheSize)
+	}
+	if o.CountsStaleness != defaultCountsStaleness {
+		t.Errorf("CountsStaleness = %v, want the flag default", o.CountsStaleness)
+	}
+	if o.DefaultFallbackTier != "spot" {
+		t.Errorf("DefaultFallbackTier = %s, want spot from the flag", o.DefaultFallbackTier)
+	}
+
+	// The fallback tier flag still wins over a reloaded policy.
+	c.Policy.Tiers[config.TierSpot] = config.Tier{NodeLabelKey: "example.com/pool", NodeLabelValue: "spot"}
+	p := o.Policy(c)
+	if p.FallbackTier != "spot" || p.Tiers[config.TierSpot].NodeLabelKey != "example.com/pool" {
+		t.Errorf("Policy() = %+v, want the fallback tier of the flag and the tiers of the file", p)
+	}
+	if o.Policy(nil).Tiers[config.TierOnDemand].NodeLabelKey != "node.kubernetes.io/capacity" {
+		t.Errorf("Policy(nil) = %+v, want the default tiers", o.Policy(nil))
+	}
+
+	// Toleration seconds unset in the file come from the flags of the tier.
+	c.Policy.Tiers[config.TierSpot] = config.Tier{NodeLabelKey: "example.com/pool", NodeLabelValue: "spot", NotReadyTolerationSeconds: ptr.To[int64](10)}
+	spot := o.Policy(c).Tiers[config.TierSpot]
+	if *spot.NotReadyTolerationSeconds != 10 || *spot.UnreachableTolerationSeconds != defaultSpotUnreachableTolerationSeconds {
+		t.Errorf("spot toleration seconds = %d, %d; want 10 from the file and %d from the flag",
+			*spot.NotReadyTolerationSeconds, *spot.UnreachableTolerationSeconds, defaultSpotUnreachableTolerationSeconds)
+	}
+	if got := *o.Policy(c).Tiers[config.TierOnDemand].NotReadyTolerationSeconds; got != defaultNotReadyTolerationSeconds {
+		t.Errorf("on-demand not-ready toleration seconds = %d, want %d", got, defaultNotReadyTolerationSeconds)
+	}
+}
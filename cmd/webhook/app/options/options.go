@@ -1,9 +1,20 @@
 package options
 
 import (
+	"crypto/tls"
+	"net"
+	"time"
+
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	componentbaseconfig "k8s.io/component-base/config"
 
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli/profileflag"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/certrotator"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/registration"
 )
 
 const (
@@ -11,8 +22,71 @@ const (
 	defaultPort          = 8443
 	defaultCertDir       = "/tmp/k8s-webhook-server/serving-certs"
 	defaultTLSMinVersion = "1.3"
+
+	defaultTLSSecretNamespace = "k8s-webhook-template"
+	defaultTLSSecretName      = "k8s-webhook-template-tls"
+
+	defaultCertSecretNamespace = "k8s-webhook-template"
+	defaultCertSecretName      = "k8s-webhook-template-serving-certs"
+	defaultCAValidity          = 10 * 365 * 24 * time.Hour
+	defaultCertValidity        = 90 * 24 * time.Hour
+	defaultCertRotateBefore    = 30 * 24 * time.Hour
+	defaultCertCheckInterval   = time.Hour
+
+	defaultWebhookConfigurationName = "k8s-webhook-template"
+	defaultWebhookServiceNamespace  = "k8s-webhook-template"
+	defaultWebhookServiceName       = "k8s-webhook-template"
+	defaultWebhookServicePort       = 443
+	defaultWebhookTimeoutSeconds    = 10
+	defaultWebhookSyncInterval      = time.Minute
+
+	defaultMaxConcurrentDecisions = 64
+	defaultDecisionBudget         = 3 * time.Second
+	defaultFallbackTier           = "spot"
+	defaultCountsStaleness        = time.Minute
+	defaultDecisionCacheSize      = 10000
+	defaultDecisionCacheTTL       = 2 * time.Minute
+	defaultShutdownDrainPeriod    = 10 * time.Second
+	defaultShutdownTimeout        = 30 * time.Second
+	defaultLeaseCleanupInterval   = 30 * time.Second
+	defaultEventBurst             = 25
+	defaultEventQPS               = 1.0 / 60
+
+	defaultNotReadyTolerationSeconds        = 300
+	defaultUnreachableTolerationSeconds     = 300
+	defaultSpotNotReadyTolerationSeconds    = 30
+	defaultSpotUnreachableTolerationSeconds = 30
+
+	defaultLeaderElectionResourceName      = "k8s-webhook"
+	defaultLeaderElectionResourceNamespace = "k8s-webhook-template"
+)
+
+const (
+	// CertSourceDir serves the certificate files of CertDir, reloaded on change.
+	CertSourceDir = "dir"
+	// CertSourceSecret serves the certificate of the TLSSecretName Secret,
+	// reloaded as soon as the Secret changes.
+	CertSourceSecret = "secret"
 )
 
+// TLSVersions maps the possible values of TLSMinVersion to their versions.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// SecureCipherSuites maps the names of the cipher suites Go considers secure to
+// their IDs. Only they are accepted in TLSCipherSuites.
+func SecureCipherSuites() map[string]uint16 {
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	return suites
+}
+
 // Options contains everything necessary to create and run webhook server.
 type Options struct {
 	// BindAddress is the IP address on which to listen for the --secure-port port.
@@ -28,11 +102,45 @@ type Options struct {
 	CertName string
 	// KeyName is the server key name. Defaults to tls.key.
 	KeyName string
+	// CertSource is where the serving certificate is loaded from. Possible values:
+	// dir, secret.
+	// Defaults to dir.
+	CertSource string
+	// TLSSecretNamespace and TLSSecretName name the kubernetes.io/tls Secret
+	// served with the secret CertSource.
+	TLSSecretNamespace string
+	TLSSecretName      string
 	// TLSMinVersion is the minimum version of TLS supported. Possible values: 1.0, 1.1, 1.2, 1.3.
 	// Some environments have automated security scans that trigger on TLS versions or insecure cipher suites, and
 	// setting TLS to 1.3 would solve both problems.
 	// Defaults to 1.3.
 	TLSMinVersion string
+	// TLSCipherSuites are the cipher suites allowed with TLS 1.2 and below, out
+	// of the ones Go considers secure. Empty keeps Go's defaults.
+	TLSCipherSuites []string
+	// ClientCAFile is a PEM bundle of the CAs signing the client certificates
+	// the webhook server accepts. When set, every client must present a
+	// certificate signed by one of them.
+	ClientCAFile string
+	// ClientCertNames restricts the accepted client certificates to those whose
+	// common name or a DNS name is one of them, such as the kube-apiserver's.
+	// Empty accepts every certificate signed by ClientCAFile.
+	ClientCertNames []string
+	// DisableHTTP2 makes the webhook server only offer HTTP/1.1.
+	DisableHTTP2 bool
+	// SelfSignedCerts makes the webhook issue its own CA and serving certificate,
+	// keep them in a Secret shared by the replicas and renew them before they
+	// expire. The serving certificate is written to CertDir, which must then be
+	// writable, and reloaded without a restart.
+	SelfSignedCerts bool
+	// CertRotation configures the self-signed certificates.
+	CertRotation certrotator.Options
+	// RegisterWebhooks makes the elected leader apply the
+	// MutatingWebhookConfiguration and ValidatingWebhookConfiguration of the
+	// registered handlers, with the current CA injected, and keep applying them.
+	RegisterWebhooks bool
+	// Registration configures the applied webhook configurations.
+	Registration registration.Options
 	// KubeAPIQPS is the QPS to use while talking with kube-apiserver.
 	KubeAPIQPS float32
 	// KubeAPIBurst is the burst to allow while talking with kube-apiserver.
@@ -47,10 +155,91 @@ type Options struct {
 	// Defaults to ":8000".
 	HealthProbeBindAddress string
 
+	// MaxConcurrentDecisions is the maximum number of pod admissions that may wait
+	// for a workload lock at the same time. Admissions beyond it are placed on the
+	// fallback tier without waiting. 0 means unlimited.
+	// Defaults to 64.
+	MaxConcurrentDecisions int
+	// DecisionBudget is the time a single pod admission may spend on counting its
+	// workload before it is placed on the fallback tier. It should stay well under
+	// the timeoutSeconds of the webhook configuration.
+	// Defaults to 3s.
+	DecisionBudget time.Duration
+	// DefaultFallbackTier is the tier used for pods that cannot be counted in time,
+	// unless the owning Deployment names its own. Possible values: on-demand, spot.
+	// Defaults to spot.
+	DefaultFallbackTier string
+	// EnableAPIServerBreaker enables the circuit breaker around the API server
+	// calls made while mutating pods. While it is open, pods are placed from the
+	// last known counts of their workload.
+	// Defaults to true.
+	EnableAPIServerBreaker bool
+	// APIServerBreaker configures the API server circuit breaker.
+	APIServerBreaker circuitbreaker.Options
+	// CountsStaleness is the maximum age of the cached counts used while the API
+	// server circuit breaker is open.
+	// Defaults to 1m.
+	CountsStaleness time.Duration
+	// DecisionCacheSize is the number of recent decisions remembered by admission
+	// request UID, so that a retried request gets the identical patch without being
+	// counted again. Each replica remembers its own decisions only: a retry served
+	// by another replica is counted again. 0 disables the cache.
+	// Defaults to 10000.
+	DecisionCacheSize int
+	// DecisionCacheTTL is how long a decision is remembered by request UID.
+	// Defaults to 2m.
+	DecisionCacheTTL time.Duration
+	// ShutdownDrainPeriod is how long the server keeps serving with failing
+	// readiness after a shutdown signal, so that it leaves the Service endpoints first.
+	// Defaults to 10s.
+	ShutdownDrainPeriod time.Duration
+	// ShutdownTimeout bounds both the wait for in-flight admissions and the release
+	// of the workload Leases still held on shutdown.
+	// Defaults to 30s.
+	ShutdownTimeout time.Duration
+	// LeaderElection configures the election of the replica that runs background
+	// loops such as the workload lease cleanup. The webhook is served by every
+	// replica regardless.
+	LeaderElection componentbaseconfig.LeaderElectionConfiguration
+	// LeaseCleanupInterval is the time between two sweeps of expired workload
+	// leases by the leader.
+	// Defaults to 30s.
+	LeaseCleanupInterval time.Duration
+	// EventBurst is the number of placement events that may be recorded at once on
+	// a single Deployment before they are rate limited.
+	// Defaults to 25.
+	EventBurst int
+	// EventQPS is the rate at which a Deployment regains the right to record
+	// placement events once its burst is spent.
+	// Defaults to one event per minute.
+	EventQPS float32
+
+	// DefaultNotReadyTolerationSeconds and DefaultUnreachableTolerationSeconds
+	// are the tolerationSeconds of the not-ready and unreachable NoExecute
+	// tolerations of on-demand pods, unless the configuration file sets them.
+	// Defaults to 300.
 	DefaultNotReadyTolerationSeconds    int64
 	DefaultUnreachableTolerationSeconds int64
+	// SpotNotReadyTolerationSeconds and SpotUnreachableTolerationSeconds are the
+	// same for spot pods. They are shorter, so that spot pods fail over sooner
+	// when their node is reclaimed.
+	// Defaults to 30.
+	SpotNotReadyTolerationSeconds    int64
+	SpotUnreachableTolerationSeconds int64
 
 	ProfileOpts profileflag.Options
+	// Audit configures the audit log of admission decisions.
+	Audit audit.Options
+
+	// ConfigFile is the path of a WebhookConfiguration file. Its settings apply
+	// unless their flag is set, its policy is reloaded when the file changes.
+	ConfigFile string
+
+	// configuration is the content of ConfigFile applied to the options.
+	configuration *config.WebhookConfiguration
+	// policyFlags are the policy flags set on the command line, which take
+	// precedence over the policy of ConfigFile, including after a reload.
+	policyFlags map[string]bool
 }
 
 // NewOptions builds an empty options.
@@ -62,6 +251,7 @@ func NewOptions() *Options {
 func (o *Options) AddFlags(flags *pflag.FlagSet) {
 	flags.Lookup("kubeconfig").Usage = "Path to kubernetes control plane kubeconfig file."
 
+	flags.StringVar(&o.ConfigFile, "config", "", "The path of a WebhookConfiguration file of apiVersion config.webhook-demo.com/v1alpha1. Flags set on the command line take precedence over it. Its policy is reloaded when the file changes.")
 	flags.StringVar(&o.BindAddress, "bind-address", defaultBindAddress,
 		"The IP address on which to listen for the --secure-port port.")
 	flags.IntVar(&o.SecurePort, "secure-port", defaultPort,
@@ -70,15 +260,72 @@ func (o *Options) AddFlags(flags *pflag.FlagSet) {
 		"The directory that contains the server key and certificate.")
 	flags.StringVar(&o.CertName, "tls-cert-file-name", "tls.crt", "The name of server certificate.")
 	flags.StringVar(&o.KeyName, "tls-private-key-file-name", "tls.key", "The name of server key.")
+	flags.StringSliceVar(&o.TLSCipherSuites, "tls-cipher-suites", nil, "Comma-separated list of cipher suites allowed with TLS 1.2 and below, out of the ones Go considers secure. If omitted, the default Go cipher suites will be used. Not supported with --tls-min-version=1.3, whose cipher suites are fixed.")
+	flags.StringVar(&o.ClientCAFile, "client-ca-file", "", "A PEM bundle of the CAs signing the client certificates the webhook server accepts. When set, every client must present a certificate signed by one of them.")
+	flags.StringSliceVar(&o.ClientCertNames, "client-cert-names", nil, "The common names or DNS names of the accepted client certificates, such as the kube-apiserver's. If omitted, every certificate signed by --client-ca-file is accepted.")
+	flags.BoolVar(&o.DisableHTTP2, "disable-http2", false, "Only offer HTTP/1.1 on the webhook server.")
+	flags.StringVar(&o.CertSource, "cert-source", CertSourceDir, "Where the serving certificate is loaded from. dir reads --tls-cert-file-name and --tls-private-key-file-name in --cert-dir, secret watches the tls.crt and tls.key of the --tls-secret-name Secret. Possible values: dir, secret.")
+	flags.StringVar(&o.TLSSecretNamespace, "tls-secret-namespace", defaultTLSSecretNamespace, "The namespace of the Secret the serving certificate is loaded from with --cert-source=secret.")
+	flags.StringVar(&o.TLSSecretName, "tls-secret-name", defaultTLSSecretName, "The name of the Secret the serving certificate is loaded from with --cert-source=secret. With --self-signed-certs, it must be the --cert-secret-name Secret.")
 	flags.StringVar(&o.TLSMinVersion, "tls-min-version", defaultTLSMinVersion, "Minimum TLS version supported. Possible values: 1.0, 1.1, 1.2, 1.3.")
+	flags.BoolVar(&o.SelfSignedCerts, "self-signed-certs", false, "Issue a self-signed CA and serving certificate, keep them in the --cert-secret-name Secret and renew them before they expire. The serving certificate is written to --cert-dir, which must be writable, and reloaded without a restart.")
+	flags.StringVar(&o.CertRotation.SecretNamespace, "cert-secret-namespace", defaultCertSecretNamespace, "The namespace of the Secret the self-signed certificates are kept in.")
+	flags.StringVar(&o.CertRotation.SecretName, "cert-secret-name", defaultCertSecretName, "The name of the Secret the self-signed certificates are kept in.")
+	flags.StringSliceVar(&o.CertRotation.DNSNames, "cert-dns-names", []string{"k8s-webhook-template.k8s-webhook-template.svc", "k8s-webhook-template.k8s-webhook-template.svc.cluster.local"}, "The DNS names the self-signed serving certificate is valid for.")
+	flags.IPSliceVar(&o.CertRotation.IPAddresses, "cert-ip-addresses", []net.IP{}, "The IP addresses the self-signed serving certificate is valid for.")
+	flags.DurationVar(&o.CertRotation.CAValidity, "cert-ca-validity", defaultCAValidity, "The lifetime of a newly issued self-signed CA.")
+	flags.DurationVar(&o.CertRotation.CertValidity, "cert-validity", defaultCertValidity, "The lifetime of a newly issued self-signed serving certificate.")
+	flags.DurationVar(&o.CertRotation.RotateBefore, "cert-rotate-before", defaultCertRotateBefore, "How long before its expiry a self-signed certificate is renewed.")
+	flags.DurationVar(&o.CertRotation.CheckInterval, "cert-check-interval", defaultCertCheckInterval, "The time between two checks of the self-signed certificates.")
+	flags.BoolVar(&o.RegisterWebhooks, "register-webhooks", false, "Apply the MutatingWebhookConfiguration and ValidatingWebhookConfiguration of the served handlers with server-side apply, inject the current CA and keep applying them on the elected leader. Run the unregister command on uninstall to delete them.")
+	flags.StringVar(&o.Registration.ConfigurationName, "webhook-configuration-name", defaultWebhookConfigurationName, "The name of the applied webhook configurations.")
+	flags.StringVar(&o.Registration.ServiceNamespace, "webhook-service-namespace", defaultWebhookServiceNamespace, "The namespace of the Service the API server calls the webhook through.")
+	flags.StringVar(&o.Registration.ServiceName, "webhook-service-name", defaultWebhookServiceName, "The name of the Service the API server calls the webhook through.")
+	flags.Int32Var(&o.Registration.ServicePort, "webhook-service-port", defaultWebhookServicePort, "The port of the Service the API server calls the webhook through.")
+	flags.StringVar(&o.Registration.URL, "webhook-url", "", "The base URL the API server calls the webhook at instead of the Service, e.g. https://192.168.254.2:8443.")
+	flags.StringVar(&o.Registration.FailurePolicy, "webhook-failure-policy", registration.FailurePolicyFail, "The failure policy of the applied webhooks. Possible values: Fail, Ignore.")
+	flags.Int32Var(&o.Registration.TimeoutSeconds, "webhook-timeout-seconds", defaultWebhookTimeoutSeconds, "The time the API server waits for the webhook, between 1 and 30 seconds.")
+	flags.StringVar(&o.Registration.CABundleFile, "webhook-ca-bundle-file", "", "The PEM file injected as caBundle when the certificates are not self-signed. Defaults to the serving certificate.")
+	flags.DurationVar(&o.Registration.SyncInterval, "webhook-sync-interval", defaultWebhookSyncInterval, "The time between two applies of the webhook configurations.")
 	flags.Float32Var(&o.KubeAPIQPS, "kube-api-qps", 40.0, "QPS to use while talking with kube-apiserver.")
 	flags.IntVar(&o.KubeAPIBurst, "kube-api-burst", 60, "Burst to use while talking with kube-apiserver.")
 	flags.StringVar(&o.MetricsBindAddress, "metrics-bind-address", ":8080", "The TCP address that the controller should bind to for serving prometheus metrics(e.g. 127.0.0.1:8080, :8080). It can be set to \"0\" to disable the metrics serving.")
 	flags.StringVar(&o.HealthProbeBindAddress, "health-probe-bind-address", ":8000", "The TCP address that the controller should bind to for serving health probes(e.g. 127.0.0.1:8000, :8000)")
 
 	// webhook flags
-	flags.Int64Var(&o.DefaultNotReadyTolerationSeconds, "default-not-ready-toleration-seconds", 300, "Indicates the tolerationSeconds of the propagation policy toleration for notReady:NoExecute that is added by default to every propagation policy that does not already have such a toleration.")
-	flags.Int64Var(&o.DefaultUnreachableTolerationSeconds, "default-unreachable-toleration-seconds", 300, "Indicates the tolerationSeconds of the propagation policy toleration for unreachable:NoExecute that is added by default to every propagation policy that does not already have such a toleration.")
+	flags.IntVar(&o.MaxConcurrentDecisions, "max-concurrent-decisions", defaultMaxConcurrentDecisions, "The maximum number of pod admissions that may wait for a workload lock at the same time. Admissions beyond it are placed on the fallback tier without waiting. 0 means unlimited.")
+	flags.DurationVar(&o.DecisionBudget, "decision-budget", defaultDecisionBudget, "The time a single pod admission may spend on counting its workload before it is placed on the fallback tier. It should stay well under the timeoutSeconds of the webhook configuration, and must be under the 1m duration of the workload Leases.")
+	flags.StringVar(&o.DefaultFallbackTier, "default-fallback-tier", defaultFallbackTier, "The tier used for pods that cannot be counted in time, unless the owning Deployment sets the webhook-demo.com/fallback-tier annotation. Possible values: on-demand, spot.")
+	flags.BoolVar(&o.EnableAPIServerBreaker, "enable-apiserver-breaker", true, "Enable the circuit breaker around the API server calls made while mutating pods. While it is open, pods are placed from the last known counts of their workload.")
+	flags.DurationVar(&o.APIServerBreaker.Window, "apiserver-breaker-window", 30*time.Second, "The window over which the API server circuit breaker computes failure and slow call rates.")
+	flags.IntVar(&o.APIServerBreaker.MinRequests, "apiserver-breaker-min-requests", 20, "The number of API server calls a window needs before the circuit breaker can trip.")
+	flags.Float64Var(&o.APIServerBreaker.FailureRateThreshold, "apiserver-breaker-failure-rate", 0.5, "The ratio of failed API server calls, in (0, 1], that trips the circuit breaker.")
+	flags.DurationVar(&o.APIServerBreaker.SlowCallDuration, "apiserver-breaker-slow-call-duration", 2*time.Second, "The latency above which an API server call counts as slow.")
+	flags.Float64Var(&o.APIServerBreaker.SlowCallRateThreshold, "apiserver-breaker-slow-call-rate", 0.5, "The ratio of slow API server calls, in (0, 1], that trips the circuit breaker.")
+	flags.DurationVar(&o.APIServerBreaker.OpenDuration, "apiserver-breaker-open-duration", 15*time.Second, "How long the API server circuit breaker stays open before it lets probe calls through.")
+	flags.IntVar(&o.APIServerBreaker.HalfOpenProbes, "apiserver-breaker-half-open-probes", 3, "The number of successful probe calls that close the API server circuit breaker.")
+	flags.DurationVar(&o.CountsStaleness, "counts-staleness", defaultCountsStaleness, "The maximum age of the cached counts used to place pods while the API server circuit breaker is open. Older counts lead to the fallback tier.")
+	flags.IntVar(&o.DecisionCacheSize, "decision-cache-size", defaultDecisionCacheSize, "The number of recent decisions remembered by admission request UID, so that a retried request gets the identical patch without being counted again. Each replica remembers its own decisions only, a retry served by another replica is counted again. 0 disables the cache.")
+	flags.DurationVar(&o.DecisionCacheTTL, "decision-cache-ttl", defaultDecisionCacheTTL, "How long a decision is remembered by admission request UID.")
+	flags.DurationVar(&o.ShutdownDrainPeriod, "shutdown-drain-period", defaultShutdownDrainPeriod, "How long the server keeps serving with failing readiness after a shutdown signal, so that it leaves the Service endpoints first.")
+	flags.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "The maximum time to wait for in-flight admissions, and then to release the workload leases still held, on shutdown.")
+	flags.DurationVar(&o.LeaseCleanupInterval, "lease-cleanup-interval", defaultLeaseCleanupInterval, "The time between two sweeps of expired workload leases by the leader.")
+	flags.IntVar(&o.EventBurst, "event-burst", defaultEventBurst, "The number of placement events that may be recorded at once on a single Deployment before they are rate limited. Similar events are also aggregated during large scale-ups.")
+	flags.Float32Var(&o.EventQPS, "event-qps", defaultEventQPS, "The rate at which a Deployment regains the right to record placement events once its burst is spent.")
+
+	// leader election flags
+	flags.BoolVar(&o.LeaderElection.LeaderElect, "leader-elect", true, "Start a leader election client and gain leadership before running background loops such as the workload lease cleanup. The webhook is served by every replica regardless.")
+	flags.StringVar(&o.LeaderElection.ResourceLock, "leader-elect-resource-lock", resourcelock.LeasesResourceLock, "The type of resource object that is used for locking during leader election. Supported options are 'leases'.")
+	flags.StringVar(&o.LeaderElection.ResourceName, "leader-elect-resource-name", defaultLeaderElectionResourceName, "The name of resource object that is used for locking during leader election.")
+	flags.StringVar(&o.LeaderElection.ResourceNamespace, "leader-elect-resource-namespace", defaultLeaderElectionResourceNamespace, "The namespace of resource object that is used for locking during leader election.")
+	flags.DurationVar(&o.LeaderElection.LeaseDuration.Duration, "leader-elect-lease-duration", 15*time.Second, "The duration that non-leader candidates will wait after observing a leadership renewal until attempting to acquire leadership of a led but unrenewed leader slot.")
+	flags.DurationVar(&o.LeaderElection.RenewDeadline.Duration, "leader-elect-renew-deadline", 10*time.Second, "The interval between attempts by the acting leader to renew a leadership slot before it stops leading. This must be less than or equal to the lease duration.")
+	flags.DurationVar(&o.LeaderElection.RetryPeriod.Duration, "leader-elect-retry-period", 2*time.Second, "The duration the clients should wait between attempting acquisition and renewal of a leadership.")
+	flags.Int64Var(&o.DefaultNotReadyTolerationSeconds, "default-not-ready-toleration-seconds", defaultNotReadyTolerationSeconds, "The tolerationSeconds of the node.kubernetes.io/not-ready:NoExecute toleration added to on-demand pods that do not already have one.")
+	flags.Int64Var(&o.DefaultUnreachableTolerationSeconds, "default-unreachable-toleration-seconds", defaultUnreachableTolerationSeconds, "The tolerationSeconds of the node.kubernetes.io/unreachable:NoExecute toleration added to on-demand pods that do not already have one.")
+	flags.Int64Var(&o.SpotNotReadyTolerationSeconds, "spot-not-ready-toleration-seconds", defaultSpotNotReadyTolerationSeconds, "The tolerationSeconds of the node.kubernetes.io/not-ready:NoExecute toleration added to spot pods that do not already have one. Shorter than the on-demand one, so that spot pods fail over sooner.")
+	flags.Int64Var(&o.SpotUnreachableTolerationSeconds, "spot-unreachable-toleration-seconds", defaultSpotUnreachableTolerationSeconds, "The tolerationSeconds of the node.kubernetes.io/unreachable:NoExecute toleration added to spot pods that do not already have one. Shorter than the on-demand one, so that spot pods fail over sooner.")
 
 	o.ProfileOpts.AddFlags(flags)
+	o.Audit.AddFlags(flags)
 }
@@ -1,11 +1,27 @@
 package options
 
 import (
+	"fmt"
 	"net"
+	"net/url"
+	"sort"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	componentbasevalidation "k8s.io/component-base/config/validation"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli/profileflag"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/certrotator"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/podapp"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/registration"
 )
 
+// webhookTimeoutMargin is the part of the webhook timeout left to answer the
+// API server once the decision budget runs out.
+const webhookTimeoutMargin = time.Second
+
 // Validate checks Options and return a slice of found errs.
 func (o *Options) Validate() field.ErrorList {
 	errs := field.ErrorList{}
@@ -19,5 +35,282 @@ func (o *Options) Validate() field.ErrorList {
 		errs = append(errs, field.Invalid(newPath.Child("SecurePort"), o.SecurePort, "must be a valid port between 0 and 65535 inclusive"))
 	}
 
+	errs = append(errs, validateTLS(o, newPath)...)
+
+	switch o.CertSource {
+	case CertSourceDir:
+	case CertSourceSecret:
+		if o.TLSSecretNamespace == "" {
+			errs = append(errs, field.Required(newPath.Child("TLSSecretNamespace"), "must be set with the secret CertSource"))
+		}
+		if o.TLSSecretName == "" {
+			errs = append(errs, field.Required(newPath.Child("TLSSecretName"), "must be set with the secret CertSource"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(newPath.Child("CertSource"), o.CertSource, []string{CertSourceDir, CertSourceSecret}))
+	}
+
+	if o.SelfSignedCerts {
+		errs = append(errs, validateCertRotation(&o.CertRotation, newPath.Child("CertRotation"))...)
+		// The CA injected into the webhook configurations is the one of the
+		// self-signed certificates, so the served certificate must be too.
+		if o.CertSource == CertSourceSecret && (o.TLSSecretNamespace != o.CertRotation.SecretNamespace || o.TLSSecretName != o.CertRotation.SecretName) {
+			errs = append(errs, field.Invalid(newPath.Child("TLSSecretName"), o.TLSSecretNamespace+"/"+o.TLSSecretName,
+				fmt.Sprintf("must be the Secret of the self-signed certificates, %s/%s, with the secret CertSource", o.CertRotation.SecretNamespace, o.CertRotation.SecretName)))
+		}
+	}
+
+	if o.RegisterWebhooks {
+		errs = append(errs, validateRegistration(&o.Registration, newPath.Child("Registration"))...)
+		// The fallback tier is only applied if the API server is still waiting
+		// once the decision budget runs out.
+		if timeout := time.Duration(o.Registration.TimeoutSeconds) * time.Second; o.DecisionBudget > 0 && o.DecisionBudget > timeout-webhookTimeoutMargin {
+			errs = append(errs, field.Invalid(newPath.Child("DecisionBudget"), o.DecisionBudget, fmt.Sprintf("must be at most the webhook timeout %s minus %s", timeout, webhookTimeoutMargin)))
+		}
+	}
+
+	if o.MaxConcurrentDecisions < 0 {
+		errs = append(errs, field.Invalid(newPath.Child("MaxConcurrentDecisions"), o.MaxConcurrentDecisions, "must be greater than or equal to 0"))
+	}
+
+	if o.DecisionBudget <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("DecisionBudget"), o.DecisionBudget, "must be greater than 0"))
+	} else if o.DecisionBudget >= podapp.WorkloadLeaseDuration {
+		// A longer decision would outlive its workload Lease, which the LeaseCleaner
+		// then deletes while the decision still holds it.
+		errs = append(errs, field.Invalid(newPath.Child("DecisionBudget"), o.DecisionBudget, fmt.Sprintf("must be less than the workload Lease duration %s", podapp.WorkloadLeaseDuration)))
+	}
+
+	if o.DefaultFallbackTier != "on-demand" && o.DefaultFallbackTier != "spot" {
+		errs = append(errs, field.NotSupported(newPath.Child("DefaultFallbackTier"), o.DefaultFallbackTier, []string{"on-demand", "spot"}))
+	}
+
+	if o.EnableAPIServerBreaker {
+		errs = append(errs, validateBreaker(&o.APIServerBreaker, newPath.Child("APIServerBreaker"))...)
+	}
+
+	if o.CountsStaleness <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("CountsStaleness"), o.CountsStaleness, "must be greater than 0"))
+	}
+
+	if o.DecisionCacheSize < 0 {
+		errs = append(errs, field.Invalid(newPath.Child("DecisionCacheSize"), o.DecisionCacheSize, "must be greater than or equal to 0"))
+	}
+
+	if o.DecisionCacheSize > 0 && o.DecisionCacheTTL <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("DecisionCacheTTL"), o.DecisionCacheTTL, "must be greater than 0"))
+	}
+
+	if o.ShutdownDrainPeriod < 0 {
+		errs = append(errs, field.Invalid(newPath.Child("ShutdownDrainPeriod"), o.ShutdownDrainPeriod, "must be greater than or equal to 0"))
+	}
+
+	if o.ShutdownTimeout <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("ShutdownTimeout"), o.ShutdownTimeout, "must be greater than 0"))
+	}
+
+	if o.LeaseCleanupInterval <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("LeaseCleanupInterval"), o.LeaseCleanupInterval, "must be greater than 0"))
+	}
+
+	if o.EventBurst <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("EventBurst"), o.EventBurst, "must be greater than 0"))
+	}
+
+	if o.EventQPS <= 0 {
+		errs = append(errs, field.Invalid(newPath.Child("EventQPS"), o.EventQPS, "must be greater than 0"))
+	}
+
+	for _, toleration := range []struct {
+		name    string
+		seconds int64
+	}{
+		{"DefaultNotReadyTolerationSeconds", o.DefaultNotReadyTolerationSeconds},
+		{"DefaultUnreachableTolerationSeconds", o.DefaultUnreachableTolerationSeconds},
+		{"SpotNotReadyTolerationSeconds", o.SpotNotReadyTolerationSeconds},
+		{"SpotUnreachableTolerationSeconds", o.SpotUnreachableTolerationSeconds},
+	} {
+		if toleration.seconds < 0 {
+			errs = append(errs, field.Invalid(newPath.Child(toleration.name), toleration.seconds, "must be greater than or equal to 0"))
+		}
+	}
+
+	errs = append(errs, componentbasevalidation.ValidateLeaderElectionConfiguration(&o.LeaderElection, newPath.Child("LeaderElection"))...)
+
+	errs = append(errs, validateAudit(&o.Audit, newPath.Child("Audit"))...)
+
+	errs = append(errs, validateProfile(&o.ProfileOpts, newPath.Child("ProfileOpts"))...)
+
+	return errs
+}
+
+func validateTLS(o *Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if _, ok := TLSVersions[o.TLSMinVersion]; !ok {
+		errs = append(errs, field.NotSupported(fldPath.Child("TLSMinVersion"), o.TLSMinVersion, sortedKeys(TLSVersions)))
+	}
+	if len(o.TLSCipherSuites) > 0 && o.TLSMinVersion == "1.3" {
+		errs = append(errs, field.Invalid(fldPath.Child("TLSCipherSuites"), o.TLSCipherSuites, "cannot be set with TLSMinVersion 1.3, whose cipher suites are fixed"))
+	}
+	secure := SecureCipherSuites()
+	for i, suite := range o.TLSCipherSuites {
+		if _, ok := secure[suite]; !ok {
+			errs = append(errs, field.NotSupported(fldPath.Child("TLSCipherSuites").Index(i), suite, sortedKeys(secure)))
+		}
+	}
+	if len(o.ClientCertNames) > 0 && o.ClientCAFile == "" {
+		errs = append(errs, field.Required(fldPath.Child("ClientCAFile"), "must be set to restrict the client certificates"))
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]uint16) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validateRegistration(r *registration.Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if r.ConfigurationName == "" {
+		errs = append(errs, field.Required(fldPath.Child("ConfigurationName"), "must be set to register the webhooks"))
+	}
+	if r.URL == "" {
+		if r.ServiceNamespace == "" {
+			errs = append(errs, field.Required(fldPath.Child("ServiceNamespace"), "must be set unless URL is"))
+		}
+		if r.ServiceName == "" {
+			errs = append(errs, field.Required(fldPath.Child("ServiceName"), "must be set unless URL is"))
+		}
+		if r.ServicePort < 1 || r.ServicePort > 65535 {
+			errs = append(errs, field.Invalid(fldPath.Child("ServicePort"), r.ServicePort, "must be a valid port between 1 and 65535 inclusive"))
+		}
+	} else if u, err := url.Parse(r.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, field.Invalid(fldPath.Child("URL"), r.URL, "must be an https URL with a host"))
+	}
+	if r.FailurePolicy != registration.FailurePolicyFail && r.FailurePolicy != registration.FailurePolicyIgnore {
+		errs = append(errs, field.NotSupported(fldPath.Child("FailurePolicy"), r.FailurePolicy, []string{registration.FailurePolicyFail, registration.FailurePolicyIgnore}))
+	}
+	if r.TimeoutSeconds < 1 || r.TimeoutSeconds > 30 {
+		errs = append(errs, field.Invalid(fldPath.Child("TimeoutSeconds"), r.TimeoutSeconds, "must be between 1 and 30 inclusive"))
+	}
+	if r.SyncInterval <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("SyncInterval"), r.SyncInterval, "must be greater than 0"))
+	}
+	return errs
+}
+
+func validateCertRotation(c *certrotator.Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if c.SecretNamespace == "" {
+		errs = append(errs, field.Required(fldPath.Child("SecretNamespace"), "must be set with self-signed certificates"))
+	}
+	if c.SecretName == "" {
+		errs = append(errs, field.Required(fldPath.Child("SecretName"), "must be set with self-signed certificates"))
+	}
+	if len(c.DNSNames) == 0 && len(c.IPAddresses) == 0 {
+		errs = append(errs, field.Required(fldPath.Child("DNSNames"), "at least one DNS name or IP address is required"))
+	}
+	if c.RotateBefore <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("RotateBefore"), c.RotateBefore, "must be greater than 0"))
+	}
+	if c.CertValidity <= c.RotateBefore {
+		errs = append(errs, field.Invalid(fldPath.Child("CertValidity"), c.CertValidity, "must be greater than RotateBefore"))
+	}
+	if c.CAValidity < c.CertValidity {
+		errs = append(errs, field.Invalid(fldPath.Child("CAValidity"), c.CAValidity, "must be greater than or equal to CertValidity"))
+	}
+	if c.CheckInterval <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("CheckInterval"), c.CheckInterval, "must be greater than 0"))
+	}
+	return errs
+}
+
+func validateProfile(p *profileflag.Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if p.ProfileOnLatency < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("ProfileOnLatency"), p.ProfileOnLatency, "must be greater than or equal to 0"))
+	}
+	if p.ProfileOnLatency == 0 {
+		return errs
+	}
+	if p.ProfileDir == "" {
+		errs = append(errs, field.Required(fldPath.Child("ProfileDir"), "must be set to capture profiles on latency"))
+	}
+	if p.ProfileMinInterval < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("ProfileMinInterval"), p.ProfileMinInterval, "must be greater than or equal to 0"))
+	}
+	if p.ProfileRetention < 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("ProfileRetention"), p.ProfileRetention, "must be greater than 0"))
+	}
+	if p.ProfileCPUDuration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("ProfileCPUDuration"), p.ProfileCPUDuration, "must be greater than 0"))
+	}
+	return errs
+}
+
+func validateAudit(a *audit.Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	switch a.Sink {
+	case audit.SinkNone:
+		return errs
+	case audit.SinkStdout:
+	case audit.SinkFile:
+		if a.Path == "" {
+			errs = append(errs, field.Required(fldPath.Child("Path"), "must be set with the file sink"))
+		}
+		if a.MaxSize <= 0 {
+			errs = append(errs, field.Invalid(fldPath.Child("MaxSize"), a.MaxSize, "must be greater than 0"))
+		}
+		if a.MaxBackups < 1 {
+			errs = append(errs, field.Invalid(fldPath.Child("MaxBackups"), a.MaxBackups, "must be greater than 0, the audit log is never deleted"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("Sink"), a.Sink, []string{audit.SinkStdout, audit.SinkFile}))
+	}
+	if a.SampleRate <= 0 || a.SampleRate > 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("SampleRate"), a.SampleRate, "must be in the range (0, 1]"))
+	}
+	for i, f := range a.Redact {
+		if f != audit.RedactUser && f != audit.RedactPatch {
+			errs = append(errs, field.NotSupported(fldPath.Child("Redact").Index(i), f, []string{audit.RedactUser, audit.RedactPatch}))
+		}
+	}
+	return errs
+}
+
+func validateBreaker(b *circuitbreaker.Options, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if b.Window <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("Window"), b.Window, "must be greater than 0"))
+	}
+	if b.MinRequests < 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("MinRequests"), b.MinRequests, "must be greater than 0"))
+	}
+	if b.FailureRateThreshold <= 0 || b.FailureRateThreshold > 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("FailureRateThreshold"), b.FailureRateThreshold, "must be in the range (0, 1]"))
+	}
+	if b.SlowCallDuration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("SlowCallDuration"), b.SlowCallDuration, "must be greater than 0"))
+	}
+	if b.SlowCallRateThreshold <= 0 || b.SlowCallRateThreshold > 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("SlowCallRateThreshold"), b.SlowCallRateThreshold, "must be in the range (0, 1]"))
+	}
+	if b.OpenDuration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("OpenDuration"), b.OpenDuration, "must be greater than 0"))
+	}
+	if b.HalfOpenProbes < 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("HalfOpenProbes"), b.HalfOpenProbes, "must be greater than 0"))
+	}
+
 	return errs
 }
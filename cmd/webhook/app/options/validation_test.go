@@ -2,8 +2,17 @@ package options
 
 import (
 	"testing"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	componentbaseconfig "k8s.io/component-base/config"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli/profileflag"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/certrotator"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/registration"
 )
 
 // a callback function to modify options
@@ -12,10 +21,43 @@ type ModifyOptions func(option *Options)
 // New an Options with default parameters
 func New(modifyOptions ModifyOptions) Options {
 	option := Options{
-		BindAddress:  "127.0.0.1",
-		SecurePort:   9000,
-		KubeAPIQPS:   40,
-		KubeAPIBurst: 30,
+		BindAddress:   "127.0.0.1",
+		SecurePort:    9000,
+		CertSource:    CertSourceDir,
+		TLSMinVersion: "1.3",
+		KubeAPIQPS:    40,
+		KubeAPIBurst:  30,
+
+		DecisionBudget:      3 * time.Second,
+		DefaultFallbackTier: "spot",
+		CountsStaleness:     time.Minute,
+		DecisionCacheSize:   10000,
+		DecisionCacheTTL:    2 * time.Minute,
+		ShutdownTimeout:     30 * time.Second,
+
+		LeaseCleanupInterval: 30 * time.Second,
+		EventBurst:           25,
+		EventQPS:             1.0 / 60,
+		LeaderElection: componentbaseconfig.LeaderElectionConfiguration{
+			LeaderElect:       true,
+			ResourceLock:      "leases",
+			ResourceName:      "k8s-webhook",
+			ResourceNamespace: "k8s-webhook-template",
+			LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:       metav1.Duration{Duration: 2 * time.Second},
+		},
+
+		EnableAPIServerBreaker: true,
+		APIServerBreaker: circuitbreaker.Options{
+			Window:                30 * time.Second,
+			MinRequests:           20,
+			FailureRateThreshold:  0.5,
+			SlowCallDuration:      2 * time.Second,
+			SlowCallRateThreshold: 0.5,
+			OpenDuration:          15 * time.Second,
+			HalfOpenProbes:        3,
+		},
 	}
 
 	if modifyOptions != nil {
@@ -50,6 +92,205 @@ func TestValidateWebhookConfiguration(t *testing.T) {
 			}),
 			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("SecurePort"), 900000, "must be a valid port between 0 and 65535 inclusive")},
 		},
+		"invalid TLSMinVersion": {
+			opt: New(func(option *Options) {
+				option.TLSMinVersion = "1.4"
+			}),
+			expectedErrs: field.ErrorList{field.NotSupported(newPath.Child("TLSMinVersion"), "1.4", []string{"1.0", "1.1", "1.2", "1.3"})},
+		},
+		"invalid TLSCipherSuites": {
+			opt: New(func(option *Options) {
+				option.TLSMinVersion = "1.2"
+				option.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}
+			}),
+			expectedErrs: field.ErrorList{field.NotSupported(newPath.Child("TLSCipherSuites").Index(1), "TLS_RSA_WITH_RC4_128_SHA", sortedKeys(SecureCipherSuites()))},
+		},
+		"TLSCipherSuites with TLS 1.3": {
+			opt: New(func(option *Options) {
+				option.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("TLSCipherSuites"), []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, "cannot be set with TLSMinVersion 1.3, whose cipher suites are fixed")},
+		},
+		"ClientCertNames without ClientCAFile": {
+			opt: New(func(option *Options) {
+				option.ClientCertNames = []string{"kube-apiserver"}
+			}),
+			expectedErrs: field.ErrorList{field.Required(newPath.Child("ClientCAFile"), "must be set to restrict the client certificates")},
+		},
+		"invalid CertSource": {
+			opt: New(func(option *Options) {
+				option.CertSource = "vault"
+			}),
+			expectedErrs: field.ErrorList{field.NotSupported(newPath.Child("CertSource"), "vault", []string{"dir", "secret"})},
+		},
+		"secret CertSource without TLSSecretName": {
+			opt: New(func(option *Options) {
+				option.CertSource = CertSourceSecret
+				option.TLSSecretNamespace = "k8s-webhook-template"
+			}),
+			expectedErrs: field.ErrorList{field.Required(newPath.Child("TLSSecretName"), "must be set with the secret CertSource")},
+		},
+		"secret CertSource with another Secret than the self-signed certificates": {
+			opt: New(func(option *Options) {
+				option.CertSource = CertSourceSecret
+				option.TLSSecretNamespace = "k8s-webhook-template"
+				option.TLSSecretName = "k8s-webhook-template-tls"
+				option.SelfSignedCerts = true
+				option.CertRotation = certrotator.Options{
+					SecretNamespace: "k8s-webhook-template",
+					SecretName:      "k8s-webhook-template-serving-certs",
+					DNSNames:        []string{"k8s-webhook-template.k8s-webhook-template.svc"},
+					CAValidity:      10 * 24 * time.Hour,
+					CertValidity:    24 * time.Hour,
+					RotateBefore:    time.Hour,
+					CheckInterval:   time.Hour,
+				}
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("TLSSecretName"), "k8s-webhook-template/k8s-webhook-template-tls",
+				"must be the Secret of the self-signed certificates, k8s-webhook-template/k8s-webhook-template-serving-certs, with the secret CertSource")},
+		},
+		"invalid CertRotation": {
+			opt: New(func(option *Options) {
+				option.SelfSignedCerts = true
+				option.CertRotation = certrotator.Options{
+					SecretNamespace: "k8s-webhook-template",
+					SecretName:      "k8s-webhook-template-serving-certs",
+					CAValidity:      time.Hour,
+					CertValidity:    24 * time.Hour,
+					RotateBefore:    time.Hour,
+					CheckInterval:   time.Hour,
+				}
+			}),
+			expectedErrs: field.ErrorList{
+				field.Required(newPath.Child("CertRotation", "DNSNames"), "at least one DNS name or IP address is required"),
+				field.Invalid(newPath.Child("CertRotation", "CAValidity"), time.Hour, "must be greater than or equal to CertValidity"),
+			},
+		},
+		"invalid Registration": {
+			opt: New(func(option *Options) {
+				option.RegisterWebhooks = true
+				option.Registration = registration.Options{
+					ConfigurationName: "k8s-webhook-template",
+					URL:               "http://192.168.254.2:8443",
+					FailurePolicy:     "Retry",
+					TimeoutSeconds:    10,
+					SyncInterval:      time.Minute,
+				}
+			}),
+			expectedErrs: field.ErrorList{
+				field.Invalid(newPath.Child("Registration", "URL"), "http://192.168.254.2:8443", "must be an https URL with a host"),
+				field.NotSupported(newPath.Child("Registration", "FailurePolicy"), "Retry", []string{"Fail", "Ignore"}),
+			},
+		},
+		"DecisionBudget not fitting in the webhook timeout": {
+			opt: New(func(option *Options) {
+				option.RegisterWebhooks = true
+				option.Registration = registration.Options{
+					ConfigurationName: "k8s-webhook-template",
+					URL:               "https://192.168.254.2:8443",
+					FailurePolicy:     "Ignore",
+					TimeoutSeconds:    3,
+					SyncInterval:      time.Minute,
+				}
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("DecisionBudget"), 3*time.Second, "must be at most the webhook timeout 3s minus 1s")},
+		},
+		"invalid MaxConcurrentDecisions": {
+			opt: New(func(option *Options) {
+				option.MaxConcurrentDecisions = -1
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("MaxConcurrentDecisions"), -1, "must be greater than or equal to 0")},
+		},
+		"invalid DecisionBudget": {
+			opt: New(func(option *Options) {
+				option.DecisionBudget = 0
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("DecisionBudget"), time.Duration(0), "must be greater than 0")},
+		},
+		"DecisionBudget outliving the workload Lease": {
+			opt: New(func(option *Options) {
+				option.DecisionBudget = time.Minute
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("DecisionBudget"), time.Minute, "must be less than the workload Lease duration 1m0s")},
+		},
+		"invalid DefaultFallbackTier": {
+			opt: New(func(option *Options) {
+				option.DefaultFallbackTier = "reserved"
+			}),
+			expectedErrs: field.ErrorList{field.NotSupported(newPath.Child("DefaultFallbackTier"), "reserved", []string{"on-demand", "spot"})},
+		},
+		"invalid APIServerBreaker FailureRateThreshold": {
+			opt: New(func(option *Options) {
+				option.APIServerBreaker.FailureRateThreshold = 1.5
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("APIServerBreaker", "FailureRateThreshold"), 1.5, "must be in the range (0, 1]")},
+		},
+		"invalid DecisionCacheTTL": {
+			opt: New(func(option *Options) {
+				option.DecisionCacheTTL = 0
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("DecisionCacheTTL"), time.Duration(0), "must be greater than 0")},
+		},
+		"invalid ShutdownTimeout": {
+			opt: New(func(option *Options) {
+				option.ShutdownTimeout = 0
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("ShutdownTimeout"), time.Duration(0), "must be greater than 0")},
+		},
+		"invalid EventBurst": {
+			opt: New(func(option *Options) {
+				option.EventBurst = 0
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("EventBurst"), 0, "must be greater than 0")},
+		},
+		"negative SpotUnreachableTolerationSeconds": {
+			opt: New(func(option *Options) {
+				option.SpotUnreachableTolerationSeconds = -1
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("SpotUnreachableTolerationSeconds"), int64(-1), "must be greater than or equal to 0")},
+		},
+		"invalid EventQPS": {
+			opt: New(func(option *Options) {
+				option.EventQPS = -1
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("EventQPS"), float32(-1), "must be greater than 0")},
+		},
+		"invalid Audit Sink": {
+			opt: New(func(option *Options) {
+				option.Audit = audit.Options{Sink: "syslog", SampleRate: 1}
+			}),
+			expectedErrs: field.ErrorList{field.NotSupported(newPath.Child("Audit", "Sink"), "syslog", []string{"stdout", "file"})},
+		},
+		"invalid Audit file sink": {
+			opt: New(func(option *Options) {
+				option.Audit = audit.Options{Sink: "file", MaxSize: 100, SampleRate: 1.5, Redact: []string{"user", "name"}}
+			}),
+			expectedErrs: field.ErrorList{
+				field.Required(newPath.Child("Audit", "Path"), "must be set with the file sink"),
+				field.Invalid(newPath.Child("Audit", "MaxBackups"), 0, "must be greater than 0, the audit log is never deleted"),
+				field.Invalid(newPath.Child("Audit", "SampleRate"), 1.5, "must be in the range (0, 1]"),
+				field.NotSupported(newPath.Child("Audit", "Redact").Index(1), "name", []string{"user", "patch"}),
+			},
+		},
+		"invalid ProfileOpts": {
+			opt: New(func(option *Options) {
+				option.ProfileOpts = profileflag.Options{ProfileOnLatency: time.Second, ProfileDir: "/tmp/profiles", ProfileCPUDuration: time.Second}
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("ProfileOpts", "ProfileRetention"), 0, "must be greater than 0")},
+		},
+		"invalid LeaderElection RenewDeadline": {
+			opt: New(func(option *Options) {
+				option.LeaderElection.RenewDeadline = metav1.Duration{Duration: 20 * time.Second}
+			}),
+			expectedErrs: field.ErrorList{field.Invalid(newPath.Child("LeaderElection", "leaseDuration"), metav1.Duration{Duration: 20 * time.Second}, "LeaseDuration must be greater than RenewDeadline")},
+		},
+		"disabled APIServerBreaker is not validated": {
+			opt: New(func(option *Options) {
+				option.EnableAPIServerBreaker = false
+				option.APIServerBreaker = circuitbreaker.Options{}
+			}),
+			expectedErrs: field.ErrorList{},
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+	podapp "github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/podapp"
+)
+
+// drainBeforeShutdown fails readiness and keeps serving for the drain period,
+// so that the replica leaves the Service endpoints before it stops accepting
+// connections, and then waits for the admissions still in flight.
+func drainBeforeShutdown(opts *options.Options, handler *podapp.MutatingAdmission) {
+	klog.Infof("Shutdown requested, failing readiness and draining for %s", opts.ShutdownDrainPeriod)
+	handler.StartDrain()
+	time.Sleep(opts.ShutdownDrainPeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	if err := handler.Drain(ctx); err != nil {
+		klog.Warningf("Stopping the webhook server before all admissions finished: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/secretcert"
+)
+
+// tlsOptions returns the TLS options of the webhook server. The options have
+// been validated, only the client CA file can still fail to load.
+func tlsOptions(opts *options.Options, certSource *secretcert.Source) ([]func(*tls.Config), error) {
+	var clientCAs *x509.CertPool
+	if opts.ClientCAFile != "" {
+		raw, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no PEM certificate found in client CA file %s", opts.ClientCAFile)
+		}
+	}
+	cipherSuites := tlsCipherSuites(opts)
+
+	return []func(*tls.Config){
+		func(config *tls.Config) {
+			config.MinVersion = options.TLSVersions[opts.TLSMinVersion]
+			if len(cipherSuites) > 0 {
+				config.CipherSuites = cipherSuites
+			}
+			if opts.DisableHTTP2 {
+				config.NextProtos = []string{"http/1.1"}
+			}
+			if clientCAs != nil {
+				config.ClientCAs = clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				if len(opts.ClientCertNames) > 0 {
+					config.VerifyConnection = verifyClientNames(opts.ClientCertNames)
+				}
+			}
+			// The Secret source serves the certificate from memory, otherwise
+			// the server watches the files in CertDir.
+			if certSource != nil {
+				config.GetCertificate = certSource.GetCertificate
+			}
+		},
+	}, nil
+}
+
+// debugTLSConfig returns the TLS configuration of the debug handlers. They
+// serve the certificate of the webhook server, so that the bearer tokens of
+// their requests do not travel in cleartext.
+func debugTLSConfig(opts *options.Options, certSource *secretcert.Source) *tls.Config {
+	config := &tls.Config{MinVersion: options.TLSVersions[opts.TLSMinVersion]}
+	if cipherSuites := tlsCipherSuites(opts); len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+	if certSource != nil {
+		config.GetCertificate = certSource.GetCertificate
+		return config
+	}
+	// The key pair is loaded on every handshake, which picks up a rotation of
+	// the files in CertDir and is cheap enough for a few debug requests.
+	certFile, keyFile := filepath.Join(opts.CertDir, opts.CertName), filepath.Join(opts.CertDir, opts.KeyName)
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+	return config
+}
+
+func tlsCipherSuites(opts *options.Options) []uint16 {
+	secure := options.SecureCipherSuites()
+	cipherSuites := make([]uint16, 0, len(opts.TLSCipherSuites))
+	for _, name := range opts.TLSCipherSuites {
+		cipherSuites = append(cipherSuites, secure[name])
+	}
+	return cipherSuites
+}
+
+// verifyClientNames rejects the connections whose verified client certificate
+// has neither a common name nor a DNS name out of names.
+func verifyClientNames(names []string) func(tls.ConnectionState) error {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("no client certificate")
+		}
+		leaf := state.PeerCertificates[0]
+		if allowed[leaf.Subject.CommonName] {
+			return nil
+		}
+		for _, name := range leaf.DNSNames {
+			if allowed[name] {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate %q is not allowed", leaf.Subject.CommonName)
+	}
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake connects a client presenting clientCert, if any, to a server
+// configured by opts and returns the error seen by the server.
+func handshake(t *testing.T, opts *options.Options, ca *testCA, clientCert *tls.Certificate) (tls.ConnectionState, error) {
+	t.Helper()
+	tlsOpts, err := tlsOptions(opts, nil)
+	if err != nil {
+		t.Fatalf("tlsOptions() error = %v", err)
+	}
+	serverConfig := &tls.Config{
+		NextProtos:   []string{"h2"},
+		Certificates: []tls.Certificate{ca.issue(t, "webhook.test", x509.ExtKeyUsageServerAuth)},
+	}
+	for _, opt := range tlsOpts {
+		opt(serverConfig)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{ServerName: "webhook.test", RootCAs: roots, NextProtos: []string{"h2", "http/1.1"}}
+	if len(opts.TLSCipherSuites) > 0 {
+		// Cipher suites only apply below TLS 1.3.
+		clientConfig.MaxVersion = tls.VersionTLS12
+	}
+	if clientCert != nil {
+		clientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	// A loopback connection rather than net.Pipe, whose unbuffered writes can
+	// deadlock the two handshakes.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		client := tls.Client(conn, clientConfig)
+		_ = client.Handshake()
+		// Reading surfaces the alert of a server rejecting the certificate.
+		_, _ = client.Read(make([]byte, 1))
+	}()
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+	_ = serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+	server := tls.Server(serverConn, serverConfig)
+	err = server.Handshake()
+	return server.ConnectionState(), err
+}
+
+func TestTLSOptions(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "client-ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	apiserver := ca.issue(t, "kube-apiserver", x509.ExtKeyUsageClientAuth)
+	other := ca.issue(t, "someone-else", x509.ExtKeyUsageClientAuth)
+	stranger := newTestCA(t).issue(t, "kube-apiserver", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name       string
+		opts       options.Options
+		clientCert *tls.Certificate
+		wantErr    bool
+		wantProto  string
+	}{
+		{name: "no client CA", opts: options.Options{TLSMinVersion: "1.3"}, wantProto: "h2"},
+		{name: "HTTP/2 disabled", opts: options.Options{TLSMinVersion: "1.3", DisableHTTP2: true}, wantProto: "http/1.1"},
+		{name: "missing client certificate", opts: options.Options{TLSMinVersion: "1.3", ClientCAFile: caFile}, wantErr: true},
+		{name: "client certificate of another CA", opts: options.Options{TLSMinVersion: "1.3", ClientCAFile: caFile}, clientCert: &stranger, wantErr: true},
+		{name: "any client certificate of the CA", opts: options.Options{TLSMinVersion: "1.3", ClientCAFile: caFile}, clientCert: &other, wantProto: "h2"},
+		{name: "allowed client name", opts: options.Options{TLSMinVersion: "1.3", ClientCAFile: caFile, ClientCertNames: []string{"kube-apiserver"}}, clientCert: &apiserver, wantProto: "h2"},
+		{name: "disallowed client name", opts: options.Options{TLSMinVersion: "1.3", ClientCAFile: caFile, ClientCertNames: []string{"kube-apiserver"}}, clientCert: &other, wantErr: true},
+		{
+			name:      "cipher suites",
+			opts:      options.Options{TLSMinVersion: "1.2", TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+			wantProto: "h2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := handshake(t, &tt.opts, ca, tt.clientCert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if state.NegotiatedProtocol != tt.wantProto {
+				t.Errorf("negotiated protocol = %q, want %q", state.NegotiatedProtocol, tt.wantProto)
+			}
+			if len(tt.opts.TLSCipherSuites) > 0 && tls.CipherSuiteName(state.CipherSuite) != tt.opts.TLSCipherSuites[0] {
+				t.Errorf("cipher suite = %s, want %s", tls.CipherSuiteName(state.CipherSuite), tt.opts.TLSCipherSuites[0])
+			}
+		})
+	}
+}
+
+func TestTLSOptionsClientCAFile(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "client-ca.crt")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{invalid, filepath.Join(t.TempDir(), "missing.crt")} {
+		if _, err := tlsOptions(&options.Options{TLSMinVersion: "1.3", ClientCAFile: file}, nil); err == nil {
+			t.Errorf("tlsOptions() error = nil for client CA file %s", file)
+		}
+	}
+}
+
+func TestDebugTLSConfig(t *testing.T) {
+	serving := newTestCA(t).issue(t, "webhook.test", x509.ExtKeyUsageServerAuth)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(serving.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serving.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The debug handlers serve the certificate of the webhook server from CertDir.
+	config := debugTLSConfig(&options.Options{TLSMinVersion: "1.2", CertDir: dir, CertName: "tls.crt", KeyName: "tls.key"}, nil)
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want TLS 1.2", config.MinVersion)
+	}
+	cert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "webhook.test" {
+		t.Errorf("served certificate = %s, want webhook.test", leaf.Subject.CommonName)
+	}
+
+	config = debugTLSConfig(&options.Options{TLSMinVersion: "1.2", CertDir: t.TempDir(), CertName: "tls.crt", KeyName: "tls.key"}, nil)
+	if _, err := config.GetCertificate(nil); err == nil {
+		t.Errorf("GetCertificate() error = nil without a key pair in CertDir")
+	}
+}
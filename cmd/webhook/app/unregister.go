@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"flag"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+
+	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/registration"
+)
+
+// NewUnregisterCommand creates the command deleting the webhook configurations
+// applied with --register-webhooks. It is meant to run on uninstall, e.g. from
+// a pre-delete hook, as the running webhook never deletes them itself.
+func NewUnregisterCommand(ctx context.Context) *cobra.Command {
+	opts := options.NewOptions()
+
+	cmd := &cobra.Command{
+		Use:   "unregister",
+		Short: "Delete the webhook configurations applied with --register-webhooks",
+		Long: `Delete the MutatingWebhookConfiguration and ValidatingWebhookConfiguration named by
+--webhook-configuration-name. Missing configurations are ignored.`,
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			config, err := controllerruntime.GetConfig()
+			if err != nil {
+				return err
+			}
+			clientset, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			registrar := &registration.Registrar{Client: clientset, Options: opts.Registration}
+			if err := registrar.Delete(ctx); err != nil {
+				return err
+			}
+			klog.Infof("Deleted webhook configurations %s", opts.Registration.ConfigurationName)
+			return nil
+		},
+	}
+	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	opts.AddFlags(cmd.Flags())
+
+	return cmd
+}
@@ -2,30 +2,54 @@ package app
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
 	"fmt"
-	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/term"
 	"k8s.io/klog/v2"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	webhookconfig "github.com/neteric/101_distributed_scheduling_s1/pkg/config"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli/klogflag"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/sharedcli/profileflag"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/certrotator"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/healthcheck"
 	gschema "github.com/neteric/101_distributed_scheduling_s1/pkg/util/schema"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/secretcert"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/version"
 	"github.com/neteric/101_distributed_scheduling_s1/pkg/version/sharedcommand"
 	podapp "github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/podapp"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/registration"
+)
+
+const (
+	// healthCheckTimeout bounds the readiness checks that wait on the informer
+	// caches, and the probes of the API server.
+	healthCheckTimeout = 2 * time.Second
+	// apiserverProbeInterval is how often the API server is probed.
+	apiserverProbeInterval = 10 * time.Second
+	// servingCertExpiryWarning is how long before its expiry the serving
+	// certificate starts being reported.
+	servingCertExpiryWarning = 7 * 24 * time.Hour
+	// webhookAppLabel is the app label of the webhook's own pods, which its
+	// webhooks are not called for to avoid a chicken-and-egg problem.
+	webhookAppLabel = "k8s-webhook-template"
 )
 
 // NewWebhookCommand creates a *cobra.Command object with default parameters
@@ -36,11 +60,21 @@ func NewWebhookCommand(ctx context.Context) *cobra.Command {
 		Use: "k8s-webhook",
 		Long: `The k8s-webhook starts a webhook server and manages policies about how to mutate and validate
 k8s resources`,
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.ConfigFile != "" {
+				cfg, err := webhookconfig.Load(opts.ConfigFile)
+				if err != nil {
+					return err
+				}
+				opts.ApplyConfiguration(cfg, cmd.Flags())
+			}
 			// validate options
 			if errs := opts.Validate(); len(errs) != 0 {
 				return errs.ToAggregate()
 			}
+			if err := klogflag.Apply(); err != nil {
+				return err
+			}
 			if err := Run(ctx, opts); err != nil {
 				return err
 			}
@@ -56,22 +90,45 @@ k8s resources`,
 		},
 	}
 	setupFlag(cmd, opts)
+	cmd.AddCommand(NewUnregisterCommand(ctx))
 
 	return cmd
 }
 
-// Run runs the webhook server with options. This should never exit.
+// Run runs the webhook server with options. It returns once the server has
+// shut down gracefully after ctx is done.
 func Run(ctx context.Context, opts *options.Options) error {
 	klog.Infof("k8s-admission-webhook version: %s", version.Get())
 
-	profileflag.ListenAndServe(opts.ProfileOpts)
-
 	config, err := controllerruntime.GetConfig()
 	if err != nil {
 		panic(err)
 	}
 	config.QPS, config.Burst = opts.KubeAPIQPS, opts.KubeAPIBurst
 
+	// Placement events are recorded per admitted pod, so large scale-ups are
+	// aggregated and rate limited per Deployment.
+	eventBroadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: opts.EventBurst,
+		QPS:       opts.EventQPS,
+	})
+	defer eventBroadcaster.Shutdown()
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	var certSource *secretcert.Source
+	if opts.CertSource == options.CertSourceSecret {
+		certSource = &secretcert.Source{Client: clientset, Namespace: opts.TLSSecretNamespace, Name: opts.TLSSecretName}
+	}
+	tlsOpts, err := tlsOptions(opts, certSource)
+	if err != nil {
+		klog.Errorf("Failed to build TLS options: %v", err)
+		return err
+	}
+
 	hookManager, err := controllerruntime.NewManager(config, controllerruntime.Options{
 		Logger: klog.Background(),
 		Scheme: gschema.NewSchema(),
@@ -81,27 +138,21 @@ func Run(ctx context.Context, opts *options.Options) error {
 			CertDir:  opts.CertDir,
 			CertName: opts.CertName,
 			KeyName:  opts.KeyName,
-			TLSOpts: []func(*tls.Config){
-				func(config *tls.Config) {
-					// Just transform the valid options as opts.TLSMinVersion
-					// can only accept "1.0", "1.1", "1.2", "1.3" and has default
-					// value,
-					switch opts.TLSMinVersion {
-					case "1.0":
-						config.MinVersion = tls.VersionTLS10
-					case "1.1":
-						config.MinVersion = tls.VersionTLS11
-					case "1.2":
-						config.MinVersion = tls.VersionTLS12
-					case "1.3":
-						config.MinVersion = tls.VersionTLS13
-					}
-				},
-			},
+			TLSOpts:  tlsOpts,
 		}),
-		LeaderElection: false,
-		Metrics:        metricsserver.Options{BindAddress: opts.MetricsBindAddress},
-		// HealthProbeBindAddress: opts.HealthProbeBindAddress,
+		// Every replica serves the webhook, the election only decides which one
+		// runs the background loops.
+		LeaderElection:                opts.LeaderElection.LeaderElect,
+		LeaderElectionID:              opts.LeaderElection.ResourceName,
+		LeaderElectionNamespace:       opts.LeaderElection.ResourceNamespace,
+		LeaderElectionResourceLock:    opts.LeaderElection.ResourceLock,
+		LeaseDuration:                 &opts.LeaderElection.LeaseDuration.Duration,
+		RenewDeadline:                 &opts.LeaderElection.RenewDeadline.Duration,
+		RetryPeriod:                   &opts.LeaderElection.RetryPeriod.Duration,
+		LeaderElectionReleaseOnCancel: true,
+		Metrics:                       metricsserver.Options{BindAddress: opts.MetricsBindAddress},
+		EventBroadcaster:              eventBroadcaster, //nolint:staticcheck // the broadcaster lives as long as the process
+		HealthProbeBindAddress:        opts.HealthProbeBindAddress,
 	})
 	if err != nil {
 		klog.Errorf("Failed to build webhook server: %v", err)
@@ -113,31 +164,224 @@ func Run(ctx context.Context, opts *options.Options) error {
 	klog.Info("Registering webhooks to the webhook server")
 	hookServer := hookManager.GetWebhookServer()
 
-	clientset, err := kubernetes.NewForConfig(hookManager.GetConfig())
+	if certSource != nil {
+		// A missing Secret is reported by the serving-cert readiness check
+		// until the watch picks it up.
+		_ = certSource.Load(ctx)
+		if err := hookManager.Add(certSource); err != nil {
+			klog.Errorf("Failed to add serving certificate source: %v", err)
+			return err
+		}
+	}
+	registrar := &registration.Registrar{
+		Client:   clientset,
+		Options:  opts.Registration,
+		CABundle: registration.FileCABundle(filepath.Join(opts.CertDir, opts.CertName)),
+	}
+	if certSource != nil {
+		registrar.CABundle = certSource.CABundle
+	}
+	if opts.Registration.CABundleFile != "" {
+		registrar.CABundle = registration.FileCABundle(opts.Registration.CABundleFile)
+	}
+	if opts.SelfSignedCerts {
+		rotator, err := addCertRotator(ctx, hookManager, clientset, opts)
+		if err != nil {
+			klog.Errorf("Failed to set up self-signed certificates: %v", err)
+			return err
+		}
+		registrar.CABundle = rotator.SecretCABundle
+	}
+
+	auditor, err := audit.New(opts.Audit)
 	if err != nil {
-		panic(err)
+		klog.Errorf("Failed to open the audit log: %v", err)
+		return err
 	}
+	defer auditor.Close()
+
+	// slow admissions capture profiles when enabled
+	capturer := profileflag.NewCapturer(opts.ProfileOpts)
+
 	// register validate admission webhook
-	hookServer.Register("/validate-pod", &webhook.Admission{
-		Handler: &podapp.ValidatingAdmission{Decoder: decoder},
-	})
+	registrar.Register(hookServer, registration.Webhook{
+		Name:           "validate-pod.webhook-demo.com",
+		Path:           "/validate-pod",
+		Rules:          podRules(admissionregistrationv1.Create, admissionregistrationv1.Update),
+		ObjectSelector: excludeWebhookPods(),
+	}, capturer.Handler(&webhook.Admission{
+		Handler: audit.WithAuditor("validate-pod", &podapp.ValidatingAdmission{Decoder: decoder}, auditor),
+	}))
 	// register mutating admission webhook
-	hookServer.Register("/mutate-pod", &webhook.Admission{
-		Handler: &podapp.MutatingAdmission{Decoder: decoder, Client: clientset},
-	})
+	mutatingHandler := &podapp.MutatingAdmission{
+		Decoder:                decoder,
+		Client:                 clientset,
+		MaxConcurrentDecisions: opts.MaxConcurrentDecisions,
+		DecisionBudget:         opts.DecisionBudget,
+		FallbackTier:           opts.DefaultFallbackTier,
+		CountsStaleness:        opts.CountsStaleness,
+		DecisionCacheSize:      opts.DecisionCacheSize,
+		DecisionCacheTTL:       opts.DecisionCacheTTL,
+		Recorder:               hookManager.GetEventRecorderFor("k8s-webhook"),
+	}
+	mutatingHandler.SetPolicy(opts.Policy(opts.Configuration()))
+	if opts.EnableAPIServerBreaker {
+		mutatingHandler.Breaker = podapp.NewAPIServerBreaker(opts.APIServerBreaker)
+	}
+	registrar.Register(hookServer, registration.Webhook{
+		Name:           "mutate-pod.webhook-demo.com",
+		Path:           "/mutate-pod",
+		Mutating:       true,
+		Rules:          podRules(admissionregistrationv1.Create),
+		ObjectSelector: excludeWebhookPods(),
+	}, capturer.Handler(&webhook.Admission{
+		Handler: audit.WithAuditor("mutate-pod", mutatingHandler, auditor),
+	}))
+	if opts.RegisterWebhooks {
+		if err := hookManager.Add(registrar); err != nil {
+			klog.Errorf("Failed to add webhook registrar: %v", err)
+			return err
+		}
+	}
 
-	hookServer.WebhookMux().Handle("/readyz/", http.StripPrefix("/readyz/", &healthz.Handler{}))
-	// hookManager.AddHealthzCheck("xxx", healthz.CheckHandler{})
-	// blocks until the context is done.
-	if err := hookManager.Start(ctx); err != nil {
+	if opts.ConfigFile != "" {
+		watcher := webhookconfig.NewWatcher(opts.ConfigFile, opts.Configuration(), func(c *webhookconfig.WebhookConfiguration) {
+			mutatingHandler.SetPolicy(opts.Policy(c))
+		})
+		if err := hookManager.Add(watcher); err != nil {
+			klog.Errorf("Failed to add configuration file watcher: %v", err)
+			return err
+		}
+	}
+
+	if err := profileflag.ListenAndServe(opts.ProfileOpts, profileflag.WithAuthentication(clientset, mutatingHandler.DebugHandler()), debugTLSConfig(opts, certSource)); err != nil {
+		klog.Errorf("Failed to start profiling: %v", err)
+		return err
+	}
+
+	if err := addLeaderLoops(hookManager, clientset, opts); err != nil {
+		klog.Errorf("Failed to add background loops: %v", err)
+		return err
+	}
+
+	if err := addHealthChecks(hookManager, clientset, mutatingHandler, certSource, opts); err != nil {
+		klog.Errorf("Failed to add health checks: %v", err)
+		return err
+	}
+
+	// The manager runs on its own context, so that a shutdown signal first fails
+	// readiness and drains in-flight admissions before the server stops.
+	managerCtx, stopManager := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopManager()
+	go func() {
+		select {
+		case <-ctx.Done():
+			drainBeforeShutdown(opts, mutatingHandler)
+			stopManager()
+		case <-managerCtx.Done():
+		}
+	}()
+
+	// blocks until the manager context is done.
+	if err := hookManager.Start(managerCtx); err != nil {
 		klog.Errorf("webhook server exits unexpectedly: %v", err)
 		return err
 	}
 
-	// never reach here
+	releaseCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	mutatingHandler.ReleaseHeldLocks(releaseCtx)
+	klog.Info("webhook server shut down")
 	return nil
 }
 
+// addCertRotator issues or loads the self-signed certificates, so that the
+// webhook server finds them in CertDir when it starts, and registers the loop
+// that renews them on every replica.
+func addCertRotator(ctx context.Context, mgr manager.Manager, client kubernetes.Interface, opts *options.Options) (*certrotator.Rotator, error) {
+	rotator := &certrotator.Rotator{
+		Client:   client,
+		Options:  opts.CertRotation,
+		CertDir:  opts.CertDir,
+		CertName: opts.CertName,
+		KeyName:  opts.KeyName,
+	}
+	if err := rotator.Ensure(ctx); err != nil {
+		return nil, err
+	}
+	return rotator, mgr.Add(rotator)
+}
+
+// podRules returns the rules matching pods for operations.
+func podRules(operations ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+		},
+	}}
+}
+
+// excludeWebhookPods returns the selector of every object but the webhook's
+// own pods.
+func excludeWebhookPods() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      "app",
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{webhookAppLabel},
+		}},
+	}
+}
+
+// addLeaderLoops registers the background loops that only run on the elected
+// leader, such as the cleanup of workload leases left behind by dead replicas.
+func addLeaderLoops(mgr manager.Manager, client kubernetes.Interface, opts *options.Options) error {
+	return mgr.Add(&podapp.LeaseCleaner{Client: client, Interval: opts.LeaseCleanupInterval})
+}
+
+// addHealthChecks registers the liveness and readiness checks served on the
+// health probe address, each under /readyz/<name> or /healthz/<name>.
+func addHealthChecks(mgr manager.Manager, client kubernetes.Interface, handler *podapp.MutatingAdmission, certSource *secretcert.Source, opts *options.Options) error {
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+	servingCert := healthcheck.ServingCert(filepath.Join(opts.CertDir, opts.CertName), servingCertExpiryWarning)
+	if certSource != nil {
+		servingCert = healthcheck.ServingCertFrom(certSource.Leaf, servingCertExpiryWarning)
+	}
+	checks := map[string]healthz.Checker{
+		"webhook-server": mgr.GetWebhookServer().StartedChecker(),
+		"serving-cert":   servingCert,
+		"workload-locks": handler.LockCheck,
+		"shutdown":       handler.DrainCheck,
+	}
+	// The webhook reads nothing through the manager cache: its only informer
+	// is the watch of the serving certificate Secret.
+	if certSource != nil {
+		checks["informer-sync"] = certSource.SyncCheck
+	}
+	// The API server and its circuit breaker are reported under their name
+	// only: an outage would otherwise turn every replica unready at once, and
+	// leave no endpoint to serve the fallback tier and the cached counts.
+	apiserver := healthcheck.NewAPIServerProbe(client.Discovery().RESTClient(), healthCheckTimeout, apiserverProbeInterval)
+	named := map[string]healthz.Checker{
+		"apiserver":         apiserver.Check,
+		"apiserver-breaker": handler.BreakerCheck,
+	}
+	for name, check := range named {
+		checks[name] = healthcheck.Named(name, check)
+	}
+	for name, check := range checks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(apiserver)
+}
+
 func setupFlag(cmd *cobra.Command, opts *options.Options) {
 	fss := cliflag.NamedFlagSets{}
 
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coorv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/ptr"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/neteric/101_distributed_scheduling_s1/cmd/webhook/app/options"
+	podapp "github.com/neteric/101_distributed_scheduling_s1/pkg/webhook/podapp"
+)
+
+// newElectedManager builds a manager that elects its leader through a Lease
+// stored in the shared election client instead of a real API server.
+func newElectedManager(t *testing.T, identity string, election kubernetes.Interface) manager.Manager {
+	t.Helper()
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: "k8s-webhook", Namespace: "k8s-webhook-template"},
+		Client:     election.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	mgr, err := controllerruntime.NewManager(&rest.Config{Host: "https://127.0.0.1:1"}, controllerruntime.Options{
+		LeaderElection:                      true,
+		LeaderElectionID:                    "k8s-webhook",
+		LeaderElectionResourceLockInterface: lock,
+		LeaseDuration:                       ptr.To(15 * time.Second),
+		RenewDeadline:                       ptr.To(10 * time.Second),
+		RetryPeriod:                         ptr.To(100 * time.Millisecond),
+		Metrics:                             metricsserver.Options{BindAddress: "0"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build manager %s: %v", identity, err)
+	}
+	return mgr
+}
+
+func newExpiredLease() *coorv1.Lease {
+	return &coorv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-5d4f8--lease",
+			Namespace: "default",
+			Labels:    map[string]string{podapp.LabelWorkloadLock: "true"},
+		},
+		Spec: coorv1.LeaseSpec{
+			AcquireTime:          &metav1.MicroTime{Time: time.Now().Add(-time.Hour)},
+			LeaseDurationSeconds: ptr.To(int32(60)),
+		},
+	}
+}
+
+func leaseExists(client kubernetes.Interface) bool {
+	_, err := client.CoordinationV1().Leases("default").Get(context.Background(), "app-5d4f8--lease", metav1.GetOptions{})
+	return err == nil
+}
+
+func TestAddLeaderLoops_OnlyLeaderRunsLoops(t *testing.T) {
+	election := fake.NewSimpleClientset()
+	opts := &options.Options{LeaseCleanupInterval: 50 * time.Millisecond}
+
+	// Each replica sweeps its own view of the cluster, so that the sweeps of the
+	// two replicas can be told apart.
+	clients := map[string]kubernetes.Interface{
+		"replica-a": fake.NewSimpleClientset(newExpiredLease()),
+		"replica-b": fake.NewSimpleClientset(newExpiredLease()),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, len(clients))
+	for identity, client := range clients {
+		mgr := newElectedManager(t, identity, election)
+		if err := addLeaderLoops(mgr, client, opts); err != nil {
+			t.Fatalf("addLeaderLoops() error = %v", err)
+		}
+		go func() {
+			errCh <- mgr.Start(ctx)
+		}()
+	}
+
+	swept := func() []string {
+		var identities []string
+		for identity, client := range clients {
+			if !leaseExists(client) {
+				identities = append(identities, identity)
+			}
+		}
+		return identities
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(swept()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no replica swept the expired lease")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Give the other replica many intervals to prove it does not sweep.
+	time.Sleep(500 * time.Millisecond)
+	if got := swept(); len(got) != 1 {
+		t.Fatalf("replicas %v swept the expired lease, want exactly one", got)
+	}
+
+	leader, err := election.CoordinationV1().Leases("k8s-webhook-template").Get(context.Background(), "k8s-webhook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the election lease: %v", err)
+	}
+	if got := swept()[0]; ptr.Deref(leader.Spec.HolderIdentity, "") != got {
+		t.Errorf("replica %s swept, but %s holds the election lease", got, ptr.Deref(leader.Spec.HolderIdentity, ""))
+	}
+
+	cancel()
+	for range clients {
+		if err := <-errCh; err != nil {
+			t.Errorf("manager exited with error: %v", err)
+		}
+	}
+}
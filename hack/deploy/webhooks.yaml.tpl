@@ -1,12 +1,12 @@
 apiVersion: admissionregistration.k8s.io/v1
 kind: MutatingWebhookConfiguration
 metadata:
-  name: k8s-webhook-template-webhook
+  name: k8s-webhook-template
   labels:
-    app: k8s-webhook-template-webhook
+    app: k8s-webhook-template
     kind: mutator
 webhooks:
-  - name: all-mark-webhook.slok.dev
+  - name: mutate-pod.webhook-demo.com
     # Avoid chicken-egg problem with our webhook deployment.
     objectSelector:
       matchExpressions:
@@ -19,49 +19,39 @@ webhooks:
       service:
         name: k8s-webhook-template
         namespace: k8s-webhook-template
-        path: /wh/mutating/allmark
+        path: /mutate-pod
       caBundle: CA_BUNDLE
     rules:
-      - operations: ["CREATE", "UPDATE"]
-        apiGroups: ["*"]
-        apiVersions: ["*"]
-        resources: ["deployments", "daemonsets", "cronjobs", "jobs", "statefulsets", "pods"]
-
-  - name: service-monitor-safer.slok.dev
-    admissionReviewVersions: ["v1"]
-    sideEffects: None
-    clientConfig:
-      service:
-        name: k8s-webhook-template
-        namespace: k8s-webhook-template
-        path: /wh/mutating/safeservicemonitor
-      caBundle: CA_BUNDLE
-    rules:
-      - operations: ["CREATE", "UPDATE"]
-        apiGroups: ["monitoring.coreos.com"]
+      - operations: ["CREATE"]
+        apiGroups: [""]
         apiVersions: ["v1"]
-        resources: ["servicemonitors"]
+        resources: ["pods"]
 
 ---
 apiVersion: admissionregistration.k8s.io/v1
 kind: ValidatingWebhookConfiguration
 metadata:
-  name: k8s-webhook-template-webhook
+  name: k8s-webhook-template
   labels:
-    app: k8s-webhook-template-webhook
+    app: k8s-webhook-template
     kind: validator
 webhooks:
-  - name: ingress-validation-webhook.slok.dev
+  - name: validate-pod.webhook-demo.com
+    objectSelector:
+      matchExpressions:
+      - key: app
+        operator: NotIn
+        values: ["k8s-webhook-template"]
     admissionReviewVersions: ["v1"]
     sideEffects: None
     clientConfig:
       service:
         name: k8s-webhook-template
         namespace: k8s-webhook-template
-        path: /wh/validating/ingress
+        path: /validate-pod
       caBundle: CA_BUNDLE
     rules:
       - operations: ["CREATE", "UPDATE"]
-        apiGroups: ["*"]
-        apiVersions: ["*"]
-        resources: ["ingresses"]
\ No newline at end of file
+        apiGroups: [""]
+        apiVersions: ["v1"]
+        resources: ["pods"]
@@ -0,0 +1,180 @@
+// Package audit keeps an append-only record of the decisions of the admission
+// webhooks.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// AnnotationReason is the key of the audit annotation in which a handler
+// explains its decision. It is also recorded by the API server audit log.
+const AnnotationReason = "reason"
+
+// Supported values of Options.Sink.
+const (
+	SinkNone   = ""
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+)
+
+// Fields that Options.Redact can hide.
+const (
+	// RedactUser replaces the user by a hash of it and drops the groups.
+	RedactUser = "user"
+	// RedactPatch drops the values of the patch operations and keeps their paths.
+	RedactPatch = "patch"
+)
+
+// Record is an admission decision.
+type Record struct {
+	Time      time.Time        `json:"time"`
+	Webhook   string           `json:"webhook"`
+	UID       types.UID        `json:"uid"`
+	User      string           `json:"user,omitempty"`
+	Groups    []string         `json:"groups,omitempty"`
+	Operation string           `json:"operation"`
+	Kind      string           `json:"kind,omitempty"`
+	Namespace string           `json:"namespace,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Allowed   bool             `json:"allowed"`
+	Code      int32            `json:"code,omitempty"`
+	Reason    string           `json:"reason,omitempty"`
+	Patch     []PatchOperation `json:"patch,omitempty"`
+	// Latency is the time spent in the handler, in seconds.
+	Latency float64 `json:"latency"`
+}
+
+// PatchOperation is a JSON patch operation applied by a mutating decision.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Sink stores records. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(r *Record) error
+	Close() error
+}
+
+// Options configures the audit log.
+type Options struct {
+	// Sink is where records are written, one of "", "stdout" or "file".
+	// Empty disables the audit log.
+	Sink string
+	// Path is the file written by the file sink.
+	Path string
+	// MaxSize is the size in megabytes at which the file is rotated.
+	MaxSize int
+	// MaxBackups is the number of rotated files kept next to Path, at least 1
+	// so that the file is never deleted on rotation.
+	MaxBackups int
+	// SampleRate is the ratio, in (0, 1], of allowed decisions that are recorded.
+	// Denied and failed decisions are always recorded.
+	SampleRate float64
+	// Redact lists the fields hidden from the records, among "user" and "patch".
+	Redact []string
+}
+
+// AddFlags adds flags to the specified FlagSet.
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Sink, "audit-sink", SinkNone, "Where the admission decisions are recorded as JSON lines. Possible values: stdout, file. Empty disables the audit log.")
+	fs.StringVar(&o.Path, "audit-log-path", "/var/log/k8s-webhook/audit.log", "The file written by the file audit sink.")
+	fs.IntVar(&o.MaxSize, "audit-log-max-size", 100, "The size in megabytes at which the audit log file is rotated.")
+	fs.IntVar(&o.MaxBackups, "audit-log-max-backups", 5, "The number of rotated audit log files to keep, at least 1.")
+	fs.Float64Var(&o.SampleRate, "audit-sample-rate", 1, "The ratio, in (0, 1], of allowed admission decisions that are recorded. Denied and failed decisions are always recorded.")
+	fs.StringSliceVar(&o.Redact, "audit-redact", nil, "The fields hidden from the audit records. Possible values: user, patch.")
+}
+
+// Auditor samples, redacts and writes records to a Sink. A nil Auditor records
+// nothing.
+type Auditor struct {
+	sink        Sink
+	sampleRate  float64
+	redactUser  bool
+	redactPatch bool
+}
+
+// New creates the Auditor configured by opts. It returns nil if the audit log is
+// disabled.
+func New(opts Options) (*Auditor, error) {
+	var sink Sink
+	switch opts.Sink {
+	case SinkNone:
+		return nil, nil
+	case SinkStdout:
+		sink = NewWriterSink(os.Stdout)
+	case SinkFile:
+		var err error
+		sink, err = NewFileSink(opts.Path, int64(opts.MaxSize)<<20, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported audit sink %q", opts.Sink)
+	}
+	return NewAuditor(sink, opts.SampleRate, opts.Redact), nil
+}
+
+// NewAuditor creates an Auditor writing to sink.
+func NewAuditor(sink Sink, sampleRate float64, redact []string) *Auditor {
+	a := &Auditor{sink: sink, sampleRate: sampleRate}
+	for _, field := range redact {
+		switch field {
+		case RedactUser:
+			a.redactUser = true
+		case RedactPatch:
+			a.redactPatch = true
+		}
+	}
+	return a
+}
+
+// Record writes r unless it is sampled out. Failures are logged, an audit log
+// that cannot be written must not block admissions.
+func (a *Auditor) Record(r *Record) {
+	if a == nil || !a.sampled(r) {
+		return
+	}
+	if a.redactUser && r.User != "" {
+		sum := sha256.Sum256([]byte(r.User))
+		r.User = "sha256:" + hex.EncodeToString(sum[:8])
+		r.Groups = nil
+	}
+	if a.redactPatch {
+		for i := range r.Patch {
+			r.Patch[i].Value = nil
+		}
+	}
+	if err := a.sink.Write(r); err != nil {
+		klog.Errorf("Failed to write the audit record of request %s: %v", r.UID, err)
+	}
+}
+
+// Close closes the underlying sink.
+func (a *Auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.sink.Close()
+}
+
+// sampled keeps every denied decision and a stable share of the allowed ones,
+// so that a retried request is either always or never recorded.
+func (a *Auditor) sampled(r *Record) bool {
+	if !r.Allowed || a.sampleRate >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(r.UID))
+	return float64(h.Sum32())/float64(1<<32) < a.sampleRate
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestWithAuditor(t *testing.T) {
+	var out bytes.Buffer
+	auditor := NewAuditor(NewWriterSink(&out), 1, []string{RedactUser, RedactPatch})
+	handler := WithAuditor("mutate-pod", admission.HandlerFunc(func(context.Context, admission.Request) admission.Response {
+		resp := admission.PatchResponseFromRaw([]byte(`{"a":"b"}`), []byte(`{"a":"c"}`))
+		resp.AuditAnnotations = map[string]string{AnnotationReason: "on-demand by below-low-water"}
+		return resp
+	}), auditor)
+
+	handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       "uid-1",
+		Operation: admissionv1.Create,
+		Namespace: "test-namespace",
+		UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller", Groups: []string{"system:serviceaccounts"}},
+	}})
+
+	var got Record
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal record %q: %v", out.String(), err)
+	}
+	if got.Webhook != "mutate-pod" || got.UID != "uid-1" || got.Operation != "CREATE" || !got.Allowed ||
+		got.Reason != "on-demand by below-low-water" {
+		t.Errorf("record = %+v, want the decision of request uid-1", got)
+	}
+	if got.User == "" || got.User == "system:serviceaccount:kube-system:replicaset-controller" || got.Groups != nil {
+		t.Errorf("record user = %q, groups = %v, want a redacted user and no groups", got.User, got.Groups)
+	}
+	if len(got.Patch) != 1 || got.Patch[0].Path != "/a" || got.Patch[0].Value != nil {
+		t.Errorf("record patch = %+v, want the path of the patch without its value", got.Patch)
+	}
+}
+
+func TestAuditor_Sampling(t *testing.T) {
+	var out bytes.Buffer
+	auditor := NewAuditor(NewWriterSink(&out), 0.5, nil)
+	recorded := 0
+	for i := 0; i < 1000; i++ {
+		before := out.Len()
+		auditor.Record(&Record{UID: types.UID(fmt.Sprintf("uid-%d", i)), Allowed: true})
+		if out.Len() > before {
+			recorded++
+		}
+	}
+	if recorded < 400 || recorded > 600 {
+		t.Errorf("recorded %d of 1000 allowed decisions, want about half", recorded)
+	}
+
+	out.Reset()
+	for i := 0; i < 10; i++ {
+		auditor.Record(&Record{UID: types.UID(fmt.Sprintf("uid-%d", i)), Allowed: false})
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 10 {
+		t.Errorf("recorded %d of 10 denied decisions, want all of them", lines)
+	}
+}
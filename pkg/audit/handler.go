@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// auditedHandler records every decision of an admission.Handler.
+type auditedHandler struct {
+	webhook string
+	handler admission.Handler
+	auditor *Auditor
+}
+
+// WithAuditor wraps handler so that every decision it makes is recorded by
+// auditor under the name webhook. It returns handler itself if auditor is nil.
+func WithAuditor(webhook string, handler admission.Handler, auditor *Auditor) admission.Handler {
+	if auditor == nil {
+		return handler
+	}
+	return &auditedHandler{webhook: webhook, handler: handler, auditor: auditor}
+}
+
+var _ admission.Handler = &auditedHandler{}
+
+func (h *auditedHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	start := time.Now()
+	resp := h.handler.Handle(ctx, req)
+	latency := time.Since(start)
+
+	r := &Record{
+		Time:      start,
+		Webhook:   h.webhook,
+		UID:       req.UID,
+		User:      req.UserInfo.Username,
+		Groups:    req.UserInfo.Groups,
+		Operation: string(req.Operation),
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		Allowed:   resp.Allowed,
+		Reason:    resp.AuditAnnotations[AnnotationReason],
+		Latency:   latency.Seconds(),
+	}
+	if resp.Result != nil {
+		r.Code = resp.Result.Code
+		if r.Reason == "" {
+			r.Reason = resp.Result.Message
+		}
+	}
+	for _, p := range resp.Patches {
+		r.Patch = append(r.Patch, PatchOperation{Op: p.Operation, Path: p.Path, Value: p.Value})
+	}
+	h.auditor.Record(r)
+	return resp
+}
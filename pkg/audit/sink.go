@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// writerSink writes records as JSON lines to an io.Writer.
+type writerSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterSink creates a Sink writing JSON lines to w, such as os.Stdout.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{enc: json.NewEncoder(w)}
+}
+
+func (s *writerSink) Write(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(r)
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends JSON lines to a file, and rotates it to path.1, path.2, ...
+// once it reaches maxSize bytes.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// NewFileSink creates a Sink appending JSON lines to path and keeping at most
+// maxBackups rotated files, at least one: a rotation never deletes path.
+func NewFileSink(path string, maxSize int64, maxBackups int) (Sink, error) {
+	if maxBackups < 1 {
+		return nil, fmt.Errorf("audit log %s must keep at least one rotated file", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.openLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) Write(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("audit log %s is closed", s.path)
+	}
+	if s.file == nil {
+		// A previous rotation failed to reopen the file.
+		if err := s.openLocked(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		// The file stays in use if it cannot be rotated, and rotation is
+		// retried on the next write.
+		if err := s.rotateLocked(); err != nil {
+			klog.Errorf("Failed to rotate audit log %s: %v", s.path, err)
+			if s.file == nil {
+				return err
+			}
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *fileSink) openLocked() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotateLocked moves the file to the first backup and opens a new one. The
+// file is reopened, rotated or not, unless that fails too, in which case
+// s.file is left nil for the next write to retry.
+func (s *fileSink) rotateLocked() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	err := s.shiftBackups()
+	if openErr := s.openLocked(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (s *fileSink) shiftBackups() error {
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.backup(1))
+}
+
+func (s *fileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSink_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	sink, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(&Record{UID: "uid", Webhook: "mutate-pod", Reason: strings.Repeat("x", 50)}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", name, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s is %d bytes, want at most 200", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s.3) error = %v, want only 2 backups", path, err)
+	}
+}
+
+func TestFileSink_RotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 200, 1)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	record := &Record{UID: "uid", Webhook: "mutate-pod", Reason: strings.Repeat("x", 50)}
+
+	// A non-empty directory in place of the backup fails the rotation.
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o750); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(record); err != nil {
+			t.Fatalf("Write() error = %v while rotation fails", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat(%s) error = %v", path, err)
+	}
+	if info.Size() <= 200 {
+		t.Errorf("%s is %d bytes, want the records written past the rotation failure", path, info.Size())
+	}
+
+	// The next write retries the rotation.
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := sink.Write(record); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Stat(%s.1) error = %v, want the file rotated", path, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 200 {
+		t.Errorf("Stat(%s) = %v, %v; want a fresh file", path, info, err)
+	}
+}
+
+func TestNewFileSink_NoBackups(t *testing.T) {
+	// Rotating without a backup would delete the audit log.
+	if _, err := NewFileSink(filepath.Join(t.TempDir(), "audit.log"), 200, 0); err == nil {
+		t.Errorf("NewFileSink() error = nil without backups")
+	}
+}
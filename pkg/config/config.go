@@ -0,0 +1,234 @@
+// Package config loads the versioned configuration file of the webhook. The
+// policy it holds is reloaded when the file changes, the other settings are
+// read once at startup.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// GroupName is the API group of the configuration file.
+	GroupName = "config.webhook-demo.com"
+	// Kind is the kind of the configuration file.
+	Kind = "WebhookConfiguration"
+)
+
+// SchemeGroupVersion is the only version of the configuration file understood.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// Tier names, the keys of Policy.Tiers.
+const (
+	TierOnDemand = "on-demand"
+	TierSpot     = "spot"
+)
+
+// WebhookConfiguration is the content of the configuration file. Unset
+// settings keep the value of their flag.
+type WebhookConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// MaxConcurrentDecisions is --max-concurrent-decisions.
+	MaxConcurrentDecisions *int `json:"maxConcurrentDecisions,omitempty"`
+	// DecisionBudget is --decision-budget.
+	DecisionBudget *metav1.Duration `json:"decisionBudget,omitempty"`
+	// CountsStaleness is --counts-staleness.
+	CountsStaleness *metav1.Duration `json:"countsStaleness,omitempty"`
+	// DecisionCacheSize is --decision-cache-size.
+	DecisionCacheSize *int `json:"decisionCacheSize,omitempty"`
+	// DecisionCacheTTL is --decision-cache-ttl.
+	DecisionCacheTTL *metav1.Duration `json:"decisionCacheTTL,omitempty"`
+
+	// Policy is how pods are placed. It is reloaded when the file changes.
+	Policy Policy `json:"policy"`
+}
+
+// Policy is how pods are placed on the tiers.
+type Policy struct {
+	// FallbackTier is the tier of pods that cannot be counted in time, unless
+	// their Deployment names one. It is --default-fallback-tier.
+	FallbackTier string `json:"fallbackTier,omitempty"`
+	// Tiers defines the on-demand and spot tiers by name.
+	Tiers map[string]Tier `json:"tiers,omitempty"`
+}
+
+// Tier defines the nodes of a tier and the pods placed on it.
+type Tier struct {
+	// NodeLabelKey and NodeLabelValue select the nodes of the tier. On-demand
+	// pods require them, spot pods prefer them.
+	NodeLabelKey   string `json:"nodeLabelKey,omitempty"`
+	NodeLabelValue string `json:"nodeLabelValue,omitempty"`
+	// DeletionCost is the pod-deletion-cost of the pods placed on the tier,
+	// unless they set one.
+	DeletionCost *int32 `json:"deletionCost,omitempty"`
+	// NotReadyTolerationSeconds and UnreachableTolerationSeconds are the
+	// tolerationSeconds of the node.kubernetes.io/not-ready and
+	// node.kubernetes.io/unreachable NoExecute tolerations of the pods placed on
+	// the tier, that is how long they stay bound to a failed node. Unset, they
+	// come from the flags of the tier.
+	NotReadyTolerationSeconds    *int64 `json:"notReadyTolerationSeconds,omitempty"`
+	UnreachableTolerationSeconds *int64 `json:"unreachableTolerationSeconds,omitempty"`
+	// Taints are the taints of the nodes of the tier, such as spot=true:NoSchedule
+	// keeping unmanaged workloads off spot nodes. The pods placed on the tier get
+	// tolerations of those they do not already tolerate.
+	Taints []corev1.Taint `json:"taints,omitempty"`
+	// PriorityClassName is the PriorityClass of the pods placed on the tier that
+	// do not name one, for instance to keep the on-demand floor through cluster
+	// pressure. It is only set if the PriorityClass exists.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// SchedulerName is the scheduler profile of the pods placed on the tier that
+	// do not name one, for instance one bin-packing spot nodes.
+	SchedulerName string `json:"schedulerName,omitempty"`
+}
+
+// DefaultPolicy returns the policy used without a configuration file.
+func DefaultPolicy() Policy {
+	p := Policy{}
+	SetDefaultsPolicy(&p)
+	return p
+}
+
+// SetDefaults fills the unset fields of the policy of c.
+func SetDefaults(c *WebhookConfiguration) {
+	SetDefaultsPolicy(&c.Policy)
+}
+
+// SetDefaultsPolicy fills the unset fields of p.
+func SetDefaultsPolicy(p *Policy) {
+	if p.FallbackTier == "" {
+		p.FallbackTier = TierSpot
+	}
+	if p.Tiers == nil {
+		p.Tiers = map[string]Tier{}
+	}
+	defaults := map[string]Tier{
+		TierOnDemand: {NodeLabelKey: "node.kubernetes.io/capacity", NodeLabelValue: TierOnDemand, DeletionCost: int32Ptr(20000)},
+		TierSpot:     {NodeLabelKey: "node.kubernetes.io/capacity", NodeLabelValue: TierSpot, DeletionCost: int32Ptr(100)},
+	}
+	for name, def := range defaults {
+		tier := p.Tiers[name]
+		if tier.NodeLabelKey == "" {
+			tier.NodeLabelKey = def.NodeLabelKey
+		}
+		if tier.NodeLabelValue == "" {
+			tier.NodeLabelValue = def.NodeLabelValue
+		}
+		if tier.DeletionCost == nil {
+			tier.DeletionCost = def.DeletionCost
+		}
+		p.Tiers[name] = tier
+	}
+}
+
+// Validate checks a defaulted configuration.
+func Validate(c *WebhookConfiguration) field.ErrorList {
+	errs := field.ErrorList{}
+	if c.APIVersion != SchemeGroupVersion.String() {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{SchemeGroupVersion.String()}))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+	}
+	return append(errs, ValidatePolicy(&c.Policy, field.NewPath("policy"))...)
+}
+
+// ValidatePolicy checks a defaulted policy.
+func ValidatePolicy(p *Policy, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	tierNames := []string{TierOnDemand, TierSpot}
+
+	if p.FallbackTier != TierOnDemand && p.FallbackTier != TierSpot {
+		errs = append(errs, field.NotSupported(fldPath.Child("fallbackTier"), p.FallbackTier, tierNames))
+	}
+	for name, tier := range p.Tiers {
+		tierPath := fldPath.Child("tiers").Key(name)
+		if name != TierOnDemand && name != TierSpot {
+			errs = append(errs, field.NotSupported(tierPath, name, tierNames))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(tier.NodeLabelKey) {
+			errs = append(errs, field.Invalid(tierPath.Child("nodeLabelKey"), tier.NodeLabelKey, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(tier.NodeLabelValue) {
+			errs = append(errs, field.Invalid(tierPath.Child("nodeLabelValue"), tier.NodeLabelValue, msg))
+		}
+		if tier.NotReadyTolerationSeconds != nil && *tier.NotReadyTolerationSeconds < 0 {
+			errs = append(errs, field.Invalid(tierPath.Child("notReadyTolerationSeconds"), *tier.NotReadyTolerationSeconds, "must be greater than or equal to 0"))
+		}
+		if tier.UnreachableTolerationSeconds != nil && *tier.UnreachableTolerationSeconds < 0 {
+			errs = append(errs, field.Invalid(tierPath.Child("unreachableTolerationSeconds"), *tier.UnreachableTolerationSeconds, "must be greater than or equal to 0"))
+		}
+		if tier.PriorityClassName != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(tier.PriorityClassName) {
+				errs = append(errs, field.Invalid(tierPath.Child("priorityClassName"), tier.PriorityClassName, msg))
+			}
+		}
+		if tier.SchedulerName != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(tier.SchedulerName) {
+				errs = append(errs, field.Invalid(tierPath.Child("schedulerName"), tier.SchedulerName, msg))
+			}
+		}
+		for i := range tier.Taints {
+			errs = append(errs, validateTaint(&tier.Taints[i], tierPath.Child("taints").Index(i))...)
+		}
+	}
+	onDemand, spot := p.Tiers[TierOnDemand], p.Tiers[TierSpot]
+	if onDemand.NodeLabelKey == spot.NodeLabelKey && onDemand.NodeLabelValue == spot.NodeLabelValue {
+		errs = append(errs, field.Invalid(fldPath.Child("tiers").Key(TierSpot).Child("nodeLabelValue"), spot.NodeLabelValue, "must select other nodes than the on-demand tier"))
+	}
+	return errs
+}
+
+func validateTaint(taint *corev1.Taint, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	for _, msg := range validation.IsQualifiedName(taint.Key) {
+		errs = append(errs, field.Invalid(fldPath.Child("key"), taint.Key, msg))
+	}
+	for _, msg := range validation.IsValidLabelValue(taint.Value) {
+		errs = append(errs, field.Invalid(fldPath.Child("value"), taint.Value, msg))
+	}
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("effect"), taint.Effect, []corev1.TaintEffect{
+			corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute,
+		}))
+	}
+	return errs
+}
+
+// Load reads, defaults and validates the configuration file at path. Unknown
+// fields are rejected.
+func Load(path string) (*WebhookConfiguration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes, defaults and validates the content of a configuration file.
+func Parse(data []byte) (*WebhookConfiguration, error) {
+	c := &WebhookConfiguration{}
+	if err := yaml.UnmarshalStrict(bytes.TrimSpace(data), c); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration file: %w", err)
+	}
+	SetDefaults(c)
+	if errs := Validate(c); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration file: %w", errs.ToAggregate())
+	}
+	return c, nil
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
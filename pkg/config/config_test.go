@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `apiVersion: config.webhook-demo.com/v1alpha1
+kind: WebhookConfiguration
+decisionBudget: 2s
+policy:
+  fallbackTier: on-demand
+  tiers:
+    spot:
+      nodeLabelKey: example.com/pool
+      deletionCost: 1
+      taints:
+      - key: spot
+        value: "true"
+        effect: NoSchedule
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.DecisionBudget == nil || c.DecisionBudget.Duration != 2*time.Second {
+		t.Errorf("decisionBudget = %v, want 2s", c.DecisionBudget)
+	}
+	if c.MaxConcurrentDecisions != nil {
+		t.Errorf("maxConcurrentDecisions = %d, want unset", *c.MaxConcurrentDecisions)
+	}
+	if c.Policy.FallbackTier != TierOnDemand {
+		t.Errorf("fallbackTier = %s, want on-demand", c.Policy.FallbackTier)
+	}
+	spot := c.Policy.Tiers[TierSpot]
+	if spot.NodeLabelKey != "example.com/pool" || spot.NodeLabelValue != TierSpot || *spot.DeletionCost != 1 {
+		t.Errorf("spot tier = %+v, want example.com/pool=spot with deletion cost 1", spot)
+	}
+	if len(spot.Taints) != 1 || spot.Taints[0].ToString() != "spot=true:NoSchedule" {
+		t.Errorf("spot taints = %v, want spot=true:NoSchedule", spot.Taints)
+	}
+	onDemand := c.Policy.Tiers[TierOnDemand]
+	if onDemand.NodeLabelKey != "node.kubernetes.io/capacity" || onDemand.NodeLabelValue != TierOnDemand || *onDemand.DeletionCost != 20000 {
+		t.Errorf("on-demand tier = %+v, want the default", onDemand)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load() error = nil for a missing file")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	const header = "apiVersion: config.webhook-demo.com/v1alpha1\nkind: WebhookConfiguration\n"
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "unknown version", data: "apiVersion: config.webhook-demo.com/v2\nkind: WebhookConfiguration\n", wantErr: "apiVersion"},
+		{name: "unknown kind", data: "apiVersion: config.webhook-demo.com/v1alpha1\nkind: Other\n", wantErr: "kind"},
+		{name: "unknown field", data: header + "policy:\n  fallback: spot\n", wantErr: "unknown field"},
+		{name: "unknown fallback tier", data: header + "policy:\n  fallbackTier: reserved\n", wantErr: "policy.fallbackTier"},
+		{name: "unknown tier", data: header + "policy:\n  tiers:\n    reserved: {}\n", wantErr: "policy.tiers[reserved]"},
+		{name: "invalid label key", data: header + "policy:\n  tiers:\n    spot:\n      nodeLabelKey: -pool\n", wantErr: "policy.tiers[spot].nodeLabelKey"},
+		{name: "negative toleration seconds", data: header + "policy:\n  tiers:\n    spot:\n      unreachableTolerationSeconds: -1\n", wantErr: "policy.tiers[spot].unreachableTolerationSeconds"},
+		{name: "invalid taint effect", data: header + "policy:\n  tiers:\n    spot:\n      taints:\n      - key: spot\n        effect: NoEvict\n", wantErr: "policy.tiers[spot].taints[0].effect"},
+		{name: "invalid priority class name", data: header + "policy:\n  tiers:\n    on-demand:\n      priorityClassName: Critical\n", wantErr: "policy.tiers[on-demand].priorityClassName"},
+		{name: "same nodes", data: header + "policy:\n  tiers:\n    spot:\n      nodeLabelValue: on-demand\n", wantErr: "policy.tiers[spot].nodeLabelValue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
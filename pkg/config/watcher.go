@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Watcher reloads the configuration file at Path when it changes and passes
+// the new configuration to OnChange. A file that fails to load is reported and
+// the previous configuration is kept.
+type Watcher struct {
+	Path string
+	// OnChange is called with every new valid configuration whose policy
+	// differs from the previous one.
+	OnChange func(*WebhookConfiguration)
+
+	current *WebhookConfiguration
+	data    []byte
+}
+
+var _ manager.LeaderElectionRunnable = &Watcher{}
+
+// NewWatcher returns a Watcher of path, whose content is current.
+func NewWatcher(path string, current *WebhookConfiguration, onChange func(*WebhookConfiguration)) *Watcher {
+	return &Watcher{Path: path, OnChange: onChange, current: current}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// serves with the policy of its file.
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the directory of the file until ctx is done. The directory is
+// watched rather than the file, since a mounted ConfigMap is updated by
+// swapping a symlink.
+func (w *Watcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch configuration file: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return fmt.Errorf("failed to watch configuration file: %w", err)
+	}
+	klog.Infof("Watching configuration file %s", w.Path)
+	// Catch up with changes made before the watch was set up.
+	w.reload()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			w.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			klog.Errorf("Error watching configuration file %s: %v", w.Path, err)
+		}
+	}
+}
+
+// reload loads the file if its content changed and hands a changed policy to
+// OnChange.
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		klog.Errorf("Keeping the current configuration: failed to read %s: %v", w.Path, err)
+		return
+	}
+	if w.data != nil && bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+	next, err := Parse(data)
+	if err != nil {
+		klog.Errorf("Keeping the current configuration: %s: %v", w.Path, err)
+		return
+	}
+
+	previous := w.current
+	w.current = next
+	if previous == nil {
+		previous = &WebhookConfiguration{}
+	} else if !startupSettingsEqual(previous, next) {
+		klog.Warningf("Configuration file %s changed settings other than the policy, they take effect on restart", w.Path)
+	}
+	if reflect.DeepEqual(previous.Policy, next.Policy) {
+		return
+	}
+	klog.Infof("Reloaded policy from configuration file %s (-old +new):\n%s", w.Path, cmp.Diff(previous.Policy, next.Policy))
+	if w.OnChange != nil {
+		w.OnChange(next)
+	}
+}
+
+func startupSettingsEqual(a, b *WebhookConfiguration) bool {
+	x, y := *a, *b
+	x.Policy, y.Policy = Policy{}, Policy{}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, policy string) {
+	t.Helper()
+	data := "apiVersion: config.webhook-demo.com/v1alpha1\nkind: WebhookConfiguration\n" + policy
+	// Written aside and renamed, as the kubelet swaps a mounted ConfigMap.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "policy:\n  fallbackTier: spot\n")
+	current, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan *WebhookConfiguration, 10)
+	w := NewWatcher(path, current, func(c *WebhookConfiguration) { changes <- c })
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() { _ = w.Start(ctx) }()
+
+	next := func() *WebhookConfiguration {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("policy change was not reported")
+			return nil
+		}
+	}
+
+	writeConfig(t, path, "policy:\n  fallbackTier: on-demand\n")
+	if got := next().Policy.FallbackTier; got != TierOnDemand {
+		t.Errorf("fallbackTier = %s, want on-demand", got)
+	}
+
+	// An invalid file keeps the current policy, the next valid one is applied.
+	writeConfig(t, path, "policy:\n  fallbackTier: reserved\n")
+	writeConfig(t, path, "policy:\n  fallbackTier: on-demand\n  tiers:\n    spot:\n      deletionCost: 5\n")
+	if got := *next().Policy.Tiers[TierSpot].DeletionCost; got != 5 {
+		t.Errorf("spot deletionCost = %d, want 5", got)
+	}
+
+	// Startup settings alone do not change the policy.
+	writeConfig(t, path, "decisionBudget: 1s\npolicy:\n  fallbackTier: on-demand\n  tiers:\n    spot:\n      deletionCost: 5\n")
+	select {
+	case c := <-changes:
+		t.Errorf("unexpected policy change %+v", c.Policy)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Relabelling a tier reloads too.
+	writeConfig(t, path, "decisionBudget: 1s\npolicy:\n  fallbackTier: on-demand\n  tiers:\n    on-demand:\n      nodeLabelKey: example.com/pool\n    spot:\n      deletionCost: 5\n")
+	if got := next().Policy.Tiers[TierOnDemand].NodeLabelKey; got != "example.com/pool" {
+		t.Errorf("on-demand nodeLabelKey = %s, want example.com/pool", got)
+	}
+}
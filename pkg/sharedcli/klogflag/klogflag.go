@@ -2,17 +2,53 @@ package klogflag
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/spf13/pflag"
+	logsapi "k8s.io/component-base/logs/api/v1"
+	logsjson "k8s.io/component-base/logs/json"
 	"k8s.io/klog/v2"
 )
 
+// Supported log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	// flagSetShim holds the klog flags, the verbosity is read back from it.
+	flagSetShim *flag.FlagSet
+	format      = FormatText
+)
+
 // Add used to add klog flags to specified flag set.
 func Add(fs *pflag.FlagSet) {
 	// Since klog only accepts golang flag set, so introduce a shim here.
-	flagSetShim := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flagSetShim = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	klog.InitFlags(flagSetShim)
 
 	fs.AddGoFlagSet(flagSetShim)
+	fs.StringVar(&format, "logging-format", FormatText, "Sets the log format. Possible values: text, json. With json, key/value pairs of contextual logging become fields of every log entry.")
+}
+
+// Apply switches klog to the log format set on the command line. It must be
+// called once the flags added by Add are parsed.
+func Apply() error {
+	switch format {
+	case FormatText:
+		return nil
+	case FormatJSON:
+		v, err := strconv.ParseUint(flagSetShim.Lookup("v").Value.String(), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid log verbosity: %w", err)
+		}
+		logger, _ := logsjson.NewJSONLogger(logsapi.VerbosityLevel(v), logsjson.AddNopSync(os.Stderr), nil, nil)
+		klog.SetLogger(logger)
+		return nil
+	default:
+		return fmt.Errorf("unsupported logging format %q, must be %s or %s", format, FormatText, FormatJSON)
+	}
 }
@@ -0,0 +1,137 @@
+package profileflag
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// reviewCacheTTL is how long the outcome of the reviews of a token on a path
+// is reused, which spares the API server a TokenReview and a
+// SubjectAccessReview per debug request.
+const reviewCacheTTL = 10 * time.Second
+
+// WithAuthentication serves handler only to the requests whose bearer token is
+// authenticated by a TokenReview and allowed to get the requested path by a
+// SubjectAccessReview, as for the non-resource URLs of the API server. The
+// outcome of the reviews is cached for reviewCacheTTL.
+func WithAuthentication(client kubernetes.Interface, handler http.Handler) http.Handler {
+	cache := &reviewCache{entries: map[reviewKey]reviewEntry{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		key := reviewKey{token: sha256.Sum256([]byte(token)), verb: strings.ToLower(r.Method), path: r.URL.Path}
+		status, ok := cache.get(key, time.Now())
+		if !ok {
+			var err error
+			if status, err = review(client, r, token); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			cache.add(key, status, time.Now())
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// review returns the status of the request r of token: OK if its token is
+// authenticated and allowed to get its path, Unauthorized or Forbidden
+// otherwise.
+func review(client kubernetes.Interface, r *http.Request, token string) (int, error) {
+	review, err := client.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		klog.Errorf("Failed to review the token of a debug request: %v", err)
+		return 0, err
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, nil
+	}
+
+	user := review.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := client.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: strings.ToLower(r.Method),
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		klog.Errorf("Failed to authorize the debug request of %s: %v", user.Username, err)
+		return 0, err
+	}
+	if !access.Status.Allowed {
+		klog.V(2).Infof("Denied debug request of %s on %s: %s", user.Username, r.URL.Path, access.Status.Reason)
+		return http.StatusForbidden, nil
+	}
+	return http.StatusOK, nil
+}
+
+// reviewKey identifies the reviews of a request by the hash of its token, so
+// that the cache holds no credentials.
+type reviewKey struct {
+	token [sha256.Size]byte
+	verb  string
+	path  string
+}
+
+type reviewEntry struct {
+	status  int
+	expires time.Time
+}
+
+// reviewCache remembers the outcome of reviews until they expire. It is safe
+// for concurrent use.
+type reviewCache struct {
+	mu      sync.Mutex
+	entries map[reviewKey]reviewEntry
+}
+
+func (c *reviewCache) get(key reviewKey, now time.Time) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return 0, false
+	}
+	return entry.status, true
+}
+
+// add caches status for key, and drops the expired entries so that the cache
+// only grows with the requests of the last reviewCacheTTL.
+func (c *reviewCache) add(key reviewKey, status int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = reviewEntry{status: status, expires: now.Add(reviewCacheTTL)}
+}
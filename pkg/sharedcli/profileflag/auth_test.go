@@ -0,0 +1,67 @@
+package profileflag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestWithAuthentication(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	tokenReviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		tokenReviews++
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status.Authenticated = review.Spec.Token != "invalid"
+		review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = review.Spec.User == "admin" && review.Spec.NonResourceAttributes.Path == "/debug/webhook/locks"
+		return true, review, nil
+	})
+	handler := WithAuthentication(client, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "no token", want: http.StatusUnauthorized},
+		{name: "invalid token", token: "invalid", want: http.StatusUnauthorized},
+		{name: "not allowed", token: "developer", want: http.StatusForbidden},
+		{name: "allowed", token: "admin", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/webhook/locks", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// The reviews of a token on a path are cached.
+	reviewed := tokenReviews
+	req := httptest.NewRequest(http.MethodGet, "/debug/webhook/locks", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || tokenReviews != reviewed {
+		t.Errorf("repeated request: status = %d and %d more TokenReviews, want %d and none", rec.Code, tokenReviews-reviewed, http.StatusOK)
+	}
+}
@@ -0,0 +1,151 @@
+package profileflag
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// captureTimeFormat names the directory of every capture, so that they sort by time.
+const captureTimeFormat = "20060102T150405Z"
+
+// Capturer writes CPU and goroutine profiles to a directory when a request
+// takes longer than a threshold. Captures are at least a minimum interval
+// apart, and only the most recent ones are kept. A nil Capturer does nothing.
+type Capturer struct {
+	threshold   time.Duration
+	dir         string
+	minInterval time.Duration
+	retention   int
+	cpuDuration time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	last      time.Time
+	capturing bool
+	// done is closed when the capture in progress, if any, completes.
+	done chan struct{}
+}
+
+// NewCapturer creates the Capturer configured by opts. It returns nil if the
+// capture on latency is disabled.
+func NewCapturer(opts Options) *Capturer {
+	if opts.ProfileOnLatency <= 0 {
+		return nil
+	}
+	return &Capturer{
+		threshold:   opts.ProfileOnLatency,
+		dir:         opts.ProfileDir,
+		minInterval: opts.ProfileMinInterval,
+		retention:   opts.ProfileRetention,
+		cpuDuration: opts.ProfileCPUDuration,
+		now:         time.Now,
+	}
+}
+
+// Handler measures the latency of every request served by next.
+func (c *Capturer) Handler(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := c.now()
+		next.ServeHTTP(w, r)
+		c.Observe(c.now().Sub(start))
+	})
+}
+
+// Observe starts a capture in the background if latency crosses the threshold
+// and no capture happened within the minimum interval.
+func (c *Capturer) Observe(latency time.Duration) {
+	if c == nil || latency < c.threshold {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.capturing || (!c.last.IsZero() && now.Sub(c.last) < c.minInterval) {
+		return
+	}
+	c.capturing, c.last = true, now
+	c.done = make(chan struct{})
+	go c.capture(now, latency, c.done)
+}
+
+func (c *Capturer) capture(at time.Time, latency time.Duration, done chan struct{}) {
+	defer func() {
+		c.mu.Lock()
+		c.capturing = false
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	dir := filepath.Join(c.dir, at.UTC().Format(captureTimeFormat))
+	klog.Infof("Request took %s, above %s, capturing profiles to %s", latency, c.threshold, dir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		klog.Errorf("Failed to create profile directory: %v", err)
+		return
+	}
+	if err := writeProfile(filepath.Join(dir, "goroutine.pprof"), func(f *os.File) error {
+		return pprof.Lookup("goroutine").WriteTo(f, 0)
+	}); err != nil {
+		klog.Errorf("Failed to capture goroutine profile: %v", err)
+	}
+	if err := writeProfile(filepath.Join(dir, "cpu.pprof"), func(f *os.File) error {
+		// Fails if a CPU profile is already running, e.g. from /debug/pprof/profile.
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		time.Sleep(c.cpuDuration)
+		pprof.StopCPUProfile()
+		return nil
+	}); err != nil {
+		klog.Errorf("Failed to capture CPU profile: %v", err)
+	}
+	if err := c.prune(); err != nil {
+		klog.Errorf("Failed to delete old profiles: %v", err)
+	}
+}
+
+// prune deletes the oldest captures beyond the retention.
+func (c *Capturer) prune() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var captures []string
+	for _, e := range entries {
+		if _, err := time.Parse(captureTimeFormat, e.Name()); e.IsDir() && err == nil {
+			captures = append(captures, e.Name())
+		}
+	}
+	sort.Strings(captures)
+	for len(captures) > c.retention {
+		if err := os.RemoveAll(filepath.Join(c.dir, captures[0])); err != nil {
+			return err
+		}
+		captures = captures[1:]
+	}
+	return nil
+}
+
+func writeProfile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
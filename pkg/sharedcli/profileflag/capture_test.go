@@ -0,0 +1,85 @@
+package profileflag
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCapturer_RateLimitedAndPruned(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCapturer(Options{
+		ProfileOnLatency:   time.Second,
+		ProfileDir:         dir,
+		ProfileMinInterval: time.Minute,
+		ProfileRetention:   2,
+		ProfileCPUDuration: 10 * time.Millisecond,
+	})
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	capture := func() bool {
+		c.Observe(2 * time.Second)
+		c.mu.Lock()
+		done, started := c.done, c.last.Equal(now)
+		c.mu.Unlock()
+		if started {
+			<-done
+		}
+		return started
+	}
+
+	c.Observe(500 * time.Millisecond)
+	if !c.last.IsZero() {
+		t.Fatalf("a fast request started a capture")
+	}
+	if !capture() {
+		t.Fatalf("a slow request did not start a capture")
+	}
+	now = now.Add(30 * time.Second)
+	if capture() {
+		t.Errorf("a capture started within the minimum interval")
+	}
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Minute)
+		if !capture() {
+			t.Fatalf("a capture did not start after the minimum interval")
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("kept %d captures, want 2", len(entries))
+	}
+	for _, name := range []string{"goroutine.pprof", "cpu.pprof"} {
+		if _, err := os.Stat(filepath.Join(dir, entries[1].Name(), name)); err != nil {
+			t.Errorf("capture %s has no %s: %v", entries[1].Name(), name, err)
+		}
+	}
+}
+
+func TestListenAndServe_BindError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	err = ListenAndServe(Options{EnableProfile: true, ProfilingBindAddress: listener.Addr().String()}, nil, nil)
+	if err == nil {
+		t.Errorf("ListenAndServe() = nil on an address in use")
+	}
+}
+
+func TestListenAndServe_DebugHandlersNeedTLS(t *testing.T) {
+	err := ListenAndServe(Options{EnableDebugHandlers: true, ProfilingBindAddress: "127.0.0.1:0"}, http.NotFoundHandler(), nil)
+	if err == nil {
+		t.Errorf("ListenAndServe() = nil for the debug handlers without TLS")
+	}
+}
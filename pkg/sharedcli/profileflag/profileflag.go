@@ -1,9 +1,12 @@
 package profileflag
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/pprof"
-	"os"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -46,12 +49,34 @@ type Options struct {
 	// ProfilingBindAddress is the TCP address for pprof profiling.
 	// Defaults to :6060 if unspecified.
 	ProfilingBindAddress string
+	// EnableDebugHandlers is the flag about whether to serve the debug handlers
+	// of the webhook on ProfilingBindAddress. They require an authenticated and
+	// authorized bearer token, so the address is then served over TLS.
+	EnableDebugHandlers bool
+	// ProfileOnLatency is the handler latency above which CPU and goroutine
+	// profiles are captured to ProfileDir. 0 disables the capture.
+	ProfileOnLatency time.Duration
+	// ProfileDir is the directory the captured profiles are written to.
+	ProfileDir string
+	// ProfileMinInterval is the minimum time between two captures.
+	ProfileMinInterval time.Duration
+	// ProfileRetention is the number of captures kept in ProfileDir, older ones
+	// are deleted.
+	ProfileRetention int
+	// ProfileCPUDuration is how long the CPU profile of a capture runs.
+	ProfileCPUDuration time.Duration
 }
 
 // AddFlags adds flags to the specified FlagSet.
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&o.EnableProfile, "enable-pprof", false, "Enable profiling via web interface host:port/debug/pprof/.")
-	fs.StringVar(&o.ProfilingBindAddress, "profiling-bind-address", ":6060", "The TCP address for serving profiling(e.g. 127.0.0.1:6060, :6060). This is only applicable if profiling is enabled.")
+	fs.StringVar(&o.ProfilingBindAddress, "profiling-bind-address", ":6060", "The TCP address for serving profiling(e.g. 127.0.0.1:6060, :6060). This is only applicable if profiling or the debug handlers are enabled.")
+	fs.BoolVar(&o.EnableDebugHandlers, "enable-debug-handlers", false, "Enable the debug handlers of the webhook via web interface host:port/debug/webhook/. Requests need a bearer token allowed to get the path. The profiling address is then served over TLS with the serving certificate of the webhook.")
+	fs.DurationVar(&o.ProfileOnLatency, "profile-on-latency", 0, "The admission latency above which CPU and goroutine profiles are captured to --profile-dir. 0 disables the capture.")
+	fs.StringVar(&o.ProfileDir, "profile-dir", "/tmp/k8s-webhook-profiles", "The directory the profiles captured on latency are written to.")
+	fs.DurationVar(&o.ProfileMinInterval, "profile-min-interval", 10*time.Minute, "The minimum time between two profile captures.")
+	fs.IntVar(&o.ProfileRetention, "profile-retention", 10, "The number of profile captures kept in --profile-dir, older ones are deleted.")
+	fs.DurationVar(&o.ProfileCPUDuration, "profile-cpu-duration", 10*time.Second, "How long the CPU profile of a capture runs.")
 }
 
 func installHandlerForPProf(mux *http.ServeMux) {
@@ -62,24 +87,46 @@ func installHandlerForPProf(mux *http.ServeMux) {
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 }
 
-// ListenAndServe start a http server to enable pprof.
-func ListenAndServe(opts Options) {
+// ListenAndServe start a http server to enable pprof, and to serve the debug
+// handlers under /debug/webhook/ if they are enabled. Since the debug handlers
+// take bearer tokens, the server is then served over TLS with tlsConfig. It
+// returns an error if the address cannot be bound.
+func ListenAndServe(opts Options, debug http.Handler, tlsConfig *tls.Config) error {
+	if !opts.EnableProfile && !opts.EnableDebugHandlers {
+		return nil
+	}
+	serveDebug := opts.EnableDebugHandlers && debug != nil
+	if serveDebug && tlsConfig == nil {
+		return errors.New("the debug handlers need TLS")
+	}
+	mux := http.NewServeMux()
 	if opts.EnableProfile {
-		mux := http.NewServeMux()
 		installHandlerForPProf(mux)
+	}
+	if serveDebug {
+		mux.Handle("/debug/webhook/", debug)
+	}
+
+	listener, err := net.Listen("tcp", opts.ProfilingBindAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen for profiling on %s: %w", opts.ProfilingBindAddress, err)
+	}
+	if serveDebug {
+		listener = tls.NewListener(listener, tlsConfig)
+		klog.Infof("Starting profiling and debug handlers on port %s over TLS", opts.ProfilingBindAddress)
+	} else {
 		klog.Infof("Starting profiling on port %s", opts.ProfilingBindAddress)
-		go func() {
-			httpServer := http.Server{
-				Addr:              opts.ProfilingBindAddress,
-				Handler:           mux,
-				ReadHeaderTimeout: ReadHeaderTimeout,
-				WriteTimeout:      WriteTimeout,
-				ReadTimeout:       ReadTimeout,
-			}
-			if err := httpServer.ListenAndServe(); err != nil {
-				klog.Errorf("Failed to enable profiling: %v", err)
-				os.Exit(1)
-			}
-		}()
 	}
+	go func() {
+		httpServer := http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: ReadHeaderTimeout,
+			WriteTimeout:      WriteTimeout,
+			ReadTimeout:       ReadTimeout,
+		}
+		if err := httpServer.Serve(listener); err != nil {
+			klog.Errorf("Profiling server stopped: %v", err)
+		}
+	}()
+	return nil
 }
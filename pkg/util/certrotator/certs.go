@@ -0,0 +1,192 @@
+package certrotator
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// clockSkew backdates NotBefore so that freshly issued certificates are
+// accepted by clients whose clock is slightly behind.
+const clockSkew = 5 * time.Minute
+
+// keyPair is a parsed certificate with its PEM encoding and, when known, the
+// PEM encoding of its private key.
+type keyPair struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	keyPEM  []byte
+}
+
+// newCA creates a self-signed CA valid for validity from now.
+func newCA(commonName string, now time.Time, validity time.Duration) (*keyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return issue(template, nil)
+}
+
+// newServingCert creates a serving certificate for dnsNames and ips signed by
+// ca. It never outlives ca.
+func newServingCert(ca *keyPair, dnsNames []string, ips []net.IP, now time.Time, validity time.Duration) (*keyPair, error) {
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	commonName := ""
+	if len(dnsNames) > 0 {
+		commonName = dnsNames[0]
+	} else if len(ips) > 0 {
+		commonName = ips[0].String()
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	return issue(template, ca)
+}
+
+// issue generates a key for template and signs it with parent, or self-signs
+// it if parent is nil.
+func issue(template *x509.Certificate, parent *keyPair) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serial
+
+	signerCert, signerKey := template, crypto.Signer(key)
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return &keyPair{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     key,
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// parseKeyPair parses the first certificate of certPEM and, if keyPEM is not
+// empty, its PKCS#8 private key.
+func parseKeyPair(certPEM, keyPEM []byte) (*keyPair, error) {
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	pair := &keyPair{cert: certs[0], certPEM: pem.EncodeToMemory(block)}
+	if len(keyPEM) == 0 {
+		return pair, nil
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM block found in key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	pair.key, pair.keyPEM = signer, keyPEM
+	return pair, nil
+}
+
+// parseCertificates parses every certificate of a PEM bundle.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// bundle returns the PEM bundle of ca followed by the certificates of previous
+// that have not expired at now, so that clients keep trusting serving
+// certificates issued by a CA that was just rotated.
+func bundle(ca *keyPair, previous []byte, now time.Time) []byte {
+	var buf bytes.Buffer
+	buf.Write(ca.certPEM)
+	old, err := parseCertificates(previous)
+	if err != nil {
+		return buf.Bytes()
+	}
+	for _, cert := range old {
+		if cert.Equal(ca.cert) || now.After(cert.NotAfter) {
+			continue
+		}
+		buf.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return buf.Bytes()
+}
+
+// covers reports whether cert is valid for every name of dnsNames and ips.
+func covers(cert *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if cert.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,278 @@
+// Package certrotator issues a self-signed CA and serving certificate for the
+// webhook server, keeps them in a Secret shared by every replica and renews them
+// before they expire.
+package certrotator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// CACertKey is the Secret key of the PEM bundle of the CA certificates that
+	// clients should trust.
+	CACertKey = "ca.crt"
+	// CAKeyKey is the Secret key of the private key of the current CA.
+	CAKeyKey = "ca.key"
+)
+
+// Options configures a Rotator.
+type Options struct {
+	// SecretNamespace and SecretName name the Secret the certificates are kept in.
+	SecretNamespace string
+	SecretName      string
+	// DNSNames and IPAddresses are the names the serving certificate is valid for.
+	DNSNames    []string
+	IPAddresses []net.IP
+	// CAValidity is the lifetime of a newly issued CA.
+	CAValidity time.Duration
+	// CertValidity is the lifetime of a newly issued serving certificate. It is
+	// capped at the expiry of its CA.
+	CertValidity time.Duration
+	// RotateBefore is how long before its expiry a certificate is renewed.
+	RotateBefore time.Duration
+	// CheckInterval is the time between two checks of the Secret.
+	CheckInterval time.Duration
+}
+
+// Rotator keeps the certificates of the Secret valid and writes the serving
+// certificate to CertDir, where the webhook server reloads it on change.
+type Rotator struct {
+	Client  kubernetes.Interface
+	Options Options
+	// CertDir, CertName and KeyName locate the files read by the webhook server.
+	CertDir  string
+	CertName string
+	KeyName  string
+
+	now func() time.Time
+
+	mu       sync.RWMutex
+	caBundle []byte
+}
+
+var _ manager.LeaderElectionRunnable = &Rotator{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// serves the webhook and needs the current certificate on its own disk.
+func (r *Rotator) NeedLeaderElection() bool {
+	return false
+}
+
+// Start checks the certificates every CheckInterval until ctx is done.
+func (r *Rotator) Start(ctx context.Context) error {
+	klog.Infof("Starting certificate rotator for Secret %s/%s with interval %s", r.Options.SecretNamespace, r.Options.SecretName, r.Options.CheckInterval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.Ensure(ctx); err != nil {
+			klog.Errorf("Failed to rotate webhook certificates: %v", err)
+		}
+	}, r.Options.CheckInterval)
+	return nil
+}
+
+// CABundle returns the PEM bundle of the CA certificates last written, or nil
+// before the first successful Ensure.
+func (r *Rotator) CABundle() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caBundle
+}
+
+// SecretCABundle returns the PEM bundle of the CA certificates currently in the
+// Secret, which may have been renewed by another replica since the last Ensure.
+func (r *Rotator) SecretCABundle(ctx context.Context) ([]byte, error) {
+	secret, err := r.Client.CoreV1().Secrets(r.Options.SecretNamespace).Get(ctx, r.Options.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	caBundle := secret.Data[CACertKey]
+	if len(caBundle) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no %s", secret.Namespace, secret.Name, CACertKey)
+	}
+	return caBundle, nil
+}
+
+// Ensure renews the certificates of the Secret if they are missing, invalid or
+// about to expire, and writes the serving certificate to CertDir. Replicas
+// racing on the Secret retry on the version written by the winner.
+func (r *Rotator) Ensure(ctx context.Context) error {
+	var secret *corev1.Secret
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		var err error
+		secret, err = r.reconcile(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.writeFiles(secret.Data); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.caBundle = secret.Data[CACertKey]
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Rotator) reconcile(ctx context.Context) (*corev1.Secret, error) {
+	secrets := r.Client.CoreV1().Secrets(r.Options.SecretNamespace)
+	secret, err := secrets.Get(ctx, r.Options.SecretName, metav1.GetOptions{})
+	exists := true
+	switch {
+	case apierrors.IsNotFound(err):
+		exists = false
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: r.Options.SecretNamespace, Name: r.Options.SecretName},
+			Type:       corev1.SecretTypeTLS,
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to get Secret %s/%s: %w", r.Options.SecretNamespace, r.Options.SecretName, err)
+	}
+
+	data, reason, err := r.renew(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return secret, nil
+	}
+	klog.Infof("Renewing webhook certificates in Secret %s/%s: %s", secret.Namespace, secret.Name, reason)
+	secret = secret.DeepCopy()
+	secret.Data = data
+	if !exists {
+		return secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	return secrets.Update(ctx, secret, metav1.UpdateOptions{})
+}
+
+// renew returns the new data of the Secret and why it was renewed, or nil data
+// if the current certificates are still good.
+func (r *Rotator) renew(data map[string][]byte) (map[string][]byte, string, error) {
+	now := r.clock()
+	rotateAt := now.Add(r.Options.RotateBefore)
+
+	ca, err := parseKeyPair(data[CACertKey], data[CAKeyKey])
+	caReason := ""
+	switch {
+	case err != nil || ca.key == nil:
+		caReason = "no valid CA"
+	case rotateAt.After(ca.cert.NotAfter):
+		caReason = fmt.Sprintf("CA expires at %s", ca.cert.NotAfter.Format(time.RFC3339))
+	}
+	if caReason != "" {
+		if ca, err = newCA(r.commonName()+"-ca", now, r.Options.CAValidity); err != nil {
+			return nil, "", err
+		}
+	}
+
+	reason := caReason
+	if reason == "" {
+		reason = r.servingReason(ca, data, rotateAt)
+	}
+	if reason == "" {
+		return nil, "", nil
+	}
+	serving, err := newServingCert(ca, r.Options.DNSNames, r.Options.IPAddresses, now, r.Options.CertValidity)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string][]byte{
+		CACertKey:               bundle(ca, data[CACertKey], now),
+		CAKeyKey:                ca.keyPEM,
+		corev1.TLSCertKey:       serving.certPEM,
+		corev1.TLSPrivateKeyKey: serving.keyPEM,
+	}, reason, nil
+}
+
+// servingReason returns why the serving certificate of data must be renewed,
+// or "" if it is still good.
+func (r *Rotator) servingReason(ca *keyPair, data map[string][]byte, rotateAt time.Time) string {
+	if _, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey]); err != nil {
+		return "no valid serving certificate"
+	}
+	serving, err := parseKeyPair(data[corev1.TLSCertKey], nil)
+	switch {
+	case err != nil:
+		return "no valid serving certificate"
+	case serving.cert.CheckSignatureFrom(ca.cert) != nil:
+		return "serving certificate is not signed by the CA"
+	case rotateAt.After(serving.cert.NotAfter):
+		return fmt.Sprintf("serving certificate expires at %s", serving.cert.NotAfter.Format(time.RFC3339))
+	case !covers(serving.cert, r.Options.DNSNames, r.Options.IPAddresses):
+		return "serving certificate does not cover the configured names"
+	}
+	return ""
+}
+
+// writeFiles writes the serving certificate and key of data to CertDir, leaving
+// unchanged files alone so that the webhook server only reloads on renewal.
+func (r *Rotator) writeFiles(data map[string][]byte) error {
+	if err := os.MkdirAll(r.CertDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	// The key goes first: the server only loads a pair once the certificate
+	// matches it.
+	if err := writeFileIfChanged(filepath.Join(r.CertDir, r.KeyName), data[corev1.TLSPrivateKeyKey], 0o600); err != nil {
+		return err
+	}
+	return writeFileIfChanged(filepath.Join(r.CertDir, r.CertName), data[corev1.TLSCertKey], 0o644)
+}
+
+// writeFileIfChanged replaces path atomically with content unless it already
+// holds it.
+func writeFileIfChanged(path string, content []byte, perm os.FileMode) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func (r *Rotator) commonName() string {
+	if len(r.Options.DNSNames) > 0 {
+		return r.Options.DNSNames[0]
+	}
+	return r.Options.SecretName
+}
+
+func (r *Rotator) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
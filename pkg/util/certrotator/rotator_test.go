@@ -0,0 +1,204 @@
+package certrotator
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newTestRotator(client *fake.Clientset, dir string, now *time.Time) *Rotator {
+	return &Rotator{
+		Client: client,
+		Options: Options{
+			SecretNamespace: "k8s-webhook-template",
+			SecretName:      "k8s-webhook-template-certs",
+			DNSNames:        []string{"k8s-webhook-template.k8s-webhook-template.svc"},
+			IPAddresses:     []net.IP{net.ParseIP("192.168.254.2")},
+			CAValidity:      365 * 24 * time.Hour,
+			CertValidity:    30 * 24 * time.Hour,
+			RotateBefore:    7 * 24 * time.Hour,
+			CheckInterval:   time.Hour,
+		},
+		CertDir:  dir,
+		CertName: "tls.crt",
+		KeyName:  "tls.key",
+		now:      func() time.Time { return *now },
+	}
+}
+
+func countUpdates(client *fake.Clientset) *int {
+	updates := 0
+	client.PrependReactor("*", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetVerb() == "create" || action.GetVerb() == "update" {
+			updates++
+		}
+		return false, nil, nil
+	})
+	return &updates
+}
+
+func getSecret(t *testing.T, client *fake.Clientset) *corev1.Secret {
+	t.Helper()
+	secret, err := client.CoreV1().Secrets("k8s-webhook-template").Get(context.TODO(), "k8s-webhook-template-certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get Secret: %v", err)
+	}
+	return secret
+}
+
+func servingCert(t *testing.T, dir string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "tls.crt"))
+	if err != nil {
+		t.Fatalf("failed to read serving certificate: %v", err)
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		t.Fatalf("failed to parse serving certificate: %v", err)
+	}
+	return certs[0]
+}
+
+func verify(t *testing.T, cert *x509.Certificate, caBundle []byte, name string, at time.Time) error {
+	t.Helper()
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		t.Fatal("empty CA bundle")
+	}
+	_, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: pool, CurrentTime: at})
+	return err
+}
+
+func TestRotator_Ensure(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	updates := countUpdates(client)
+	dir := filepath.Join(t.TempDir(), "certs")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newTestRotator(client, dir, &now)
+
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	secret := getSecret(t, client)
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("Secret type = %s, want %s", secret.Type, corev1.SecretTypeTLS)
+	}
+	cert := servingCert(t, dir)
+	if !bytes.Equal(r.CABundle(), secret.Data[CACertKey]) {
+		t.Error("CABundle() does not match the Secret")
+	}
+	for _, name := range []string{"k8s-webhook-template.k8s-webhook-template.svc", "192.168.254.2"} {
+		if err := verify(t, cert, r.CABundle(), name, now); err != nil {
+			t.Errorf("serving certificate does not verify for %s: %v", name, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "tls.key")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file = %v, %v; want mode 0600", info, err)
+	}
+
+	// Nothing is due yet.
+	now = now.Add(time.Hour)
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if *updates != 1 {
+		t.Errorf("Secret written %d times, want 1", *updates)
+	}
+
+	// The serving certificate is renewed within RotateBefore of its expiry, by
+	// the same CA.
+	now = cert.NotAfter.Add(-24 * time.Hour)
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	renewed := servingCert(t, dir)
+	if renewed.Equal(cert) {
+		t.Fatal("serving certificate was not renewed")
+	}
+	if !bytes.Equal(getSecret(t, client).Data[CAKeyKey], secret.Data[CAKeyKey]) {
+		t.Error("CA was rotated along with the serving certificate")
+	}
+	if err := verify(t, renewed, r.CABundle(), "k8s-webhook-template.k8s-webhook-template.svc", now); err != nil {
+		t.Errorf("renewed certificate does not verify: %v", err)
+	}
+}
+
+func TestRotator_EnsureRotatesCA(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newTestRotator(client, dir, &now)
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	oldCert := servingCert(t, dir)
+
+	now = now.Add(r.Options.CAValidity - 24*time.Hour)
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	certs, err := parseCertificates(r.CABundle())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("CA bundle has %d certificates, want the new and the previous CA", len(certs))
+	}
+	if err := verify(t, servingCert(t, dir), r.CABundle(), "k8s-webhook-template.k8s-webhook-template.svc", now); err != nil {
+		t.Errorf("new serving certificate does not verify: %v", err)
+	}
+	if err := verify(t, oldCert, r.CABundle(), "k8s-webhook-template.k8s-webhook-template.svc", oldCert.NotBefore.Add(time.Hour)); err != nil {
+		t.Errorf("previous serving certificate no longer verifies: %v", err)
+	}
+}
+
+func TestRotator_EnsureRenewsOnNewNames(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newTestRotator(client, dir, &now)
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+
+	r.Options.DNSNames = append(r.Options.DNSNames, "webhook.example.com")
+	if err := r.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if err := servingCert(t, dir).VerifyHostname("webhook.example.com"); err != nil {
+		t.Errorf("serving certificate does not cover the new name: %v", err)
+	}
+}
+
+func TestRotator_EnsureUsesSecretOfOtherReplica(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := newTestRotator(client, t.TempDir(), &now)
+	if err := first.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+
+	updates := countUpdates(client)
+	secondDir := t.TempDir()
+	second := newTestRotator(client, secondDir, &now)
+	if err := second.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if *updates != 0 {
+		t.Errorf("Secret written %d times, want 0", *updates)
+	}
+	if !servingCert(t, secondDir).Equal(servingCert(t, first.CertDir)) {
+		t.Error("replicas serve different certificates")
+	}
+}
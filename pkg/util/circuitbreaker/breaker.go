@@ -0,0 +1,205 @@
+// Package circuitbreaker stops calling a degraded dependency until it recovers.
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Do while the breaker rejects calls.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every call through and watches their outcome.
+	Closed State = iota
+	// HalfOpen lets a limited number of probe calls through to test recovery.
+	HalfOpen
+	// Open rejects every call until OpenDuration has passed.
+	Open
+)
+
+// String returns the lower-case name of the state.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Options configures a Breaker.
+type Options struct {
+	// Window is the length of the tumbling window over which the failure and
+	// slow call rates are computed.
+	Window time.Duration
+	// MinRequests is the number of calls a window needs before it can trip the breaker.
+	MinRequests int
+	// FailureRateThreshold is the ratio of failed calls, in (0, 1], that trips the breaker.
+	FailureRateThreshold float64
+	// SlowCallDuration is the latency above which a call counts as slow.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold is the ratio of slow calls, in (0, 1], that trips the breaker.
+	SlowCallRateThreshold float64
+	// OpenDuration is how long the breaker stays open before probing.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of successful probes that close the breaker.
+	// At most this many probes are in flight at once.
+	HalfOpenProbes int
+	// IsFailure reports whether an error returned by a call counts as a failure.
+	// Defaults to every non-nil error.
+	IsFailure func(error) bool
+	// OnStateChange is called, with the breaker locked, after every transition.
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	opts Options
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	slowCalls   int
+	openedAt    time.Time
+	probes      int
+	probeOK     int
+}
+
+// New creates a closed Breaker.
+func New(opts Options) *Breaker {
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool { return err != nil }
+	}
+	if opts.HalfOpenProbes < 1 {
+		opts.HalfOpenProbes = 1
+	}
+	b := &Breaker{opts: opts, now: time.Now}
+	b.windowStart = b.now()
+	return b
+}
+
+// State returns the current state, moving an expired open breaker to half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advanceLocked(b.now())
+	return b.state
+}
+
+// Do calls fn unless the breaker is open, and records its outcome.
+func (b *Breaker) Do(fn func() error) error {
+	if !b.allow() {
+		return ErrOpen
+	}
+	start := b.now()
+	err := fn()
+	b.record(start, b.opts.IsFailure(err))
+	return err
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advanceLocked(b.now())
+	switch b.state {
+	case Open:
+		return false
+	case HalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *Breaker) record(start time.Time, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	slow := b.opts.SlowCallDuration > 0 && now.Sub(start) > b.opts.SlowCallDuration
+	switch b.state {
+	case HalfOpen:
+		b.probes--
+		if failed || slow {
+			b.setStateLocked(Open, now)
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.opts.HalfOpenProbes {
+			b.setStateLocked(Closed, now)
+		}
+	case Closed:
+		b.advanceLocked(now)
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slowCalls++
+		}
+		if b.shouldTripLocked() {
+			b.setStateLocked(Open, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldTripLocked() bool {
+	if b.requests < b.opts.MinRequests || b.requests == 0 {
+		return false
+	}
+	total := float64(b.requests)
+	if b.opts.FailureRateThreshold > 0 && float64(b.failures)/total >= b.opts.FailureRateThreshold {
+		return true
+	}
+	return b.opts.SlowCallRateThreshold > 0 && float64(b.slowCalls)/total >= b.opts.SlowCallRateThreshold
+}
+
+// advanceLocked starts a new window when the current one has passed and moves
+// an open breaker to half-open once OpenDuration has passed.
+func (b *Breaker) advanceLocked(now time.Time) {
+	switch b.state {
+	case Closed:
+		if b.opts.Window > 0 && now.Sub(b.windowStart) >= b.opts.Window {
+			b.resetWindowLocked(now)
+		}
+	case Open:
+		if now.Sub(b.openedAt) >= b.opts.OpenDuration {
+			b.setStateLocked(HalfOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) setStateLocked(to State, now time.Time) {
+	from := b.state
+	b.state = to
+	b.probes, b.probeOK = 0, 0
+	switch to {
+	case Open:
+		b.openedAt = now
+	case Closed:
+		b.resetWindowLocked(now)
+	}
+	if b.opts.OnStateChange != nil && from != to {
+		b.opts.OnStateChange(from, to)
+	}
+}
+
+func (b *Breaker) resetWindowLocked(now time.Time) {
+	b.windowStart = now
+	b.requests, b.failures, b.slowCalls = 0, 0, 0
+}
@@ -0,0 +1,147 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(opts Options) (*Breaker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := New(opts)
+	b.now = clock.Now
+	b.windowStart = clock.now
+	return b, clock
+}
+
+var errCall = errors.New("call failed")
+
+func TestBreaker_TripsOnFailureRate(t *testing.T) {
+	var transitions []State
+	b, clock := newTestBreaker(Options{
+		Window:               time.Minute,
+		MinRequests:          4,
+		FailureRateThreshold: 0.5,
+		OpenDuration:         10 * time.Second,
+		HalfOpenProbes:       2,
+		OnStateChange: func(_, to State) {
+			transitions = append(transitions, to)
+		},
+	})
+
+	for _, err := range []error{nil, errCall, nil} {
+		_ = b.Do(func() error { return err })
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v before MinRequests, want %v", got, Closed)
+	}
+	_ = b.Do(func() error { return errCall })
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v, want %v", got, Open)
+	}
+	if err := b.Do(func() error { return nil }); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Do() error = %v while open, want %v", err, ErrOpen)
+	}
+
+	clock.now = clock.now.Add(10 * time.Second)
+	if got := b.State(); got != HalfOpen {
+		t.Fatalf("State() = %v after OpenDuration, want %v", got, HalfOpen)
+	}
+	for i := 0; i < 2; i++ {
+		if err := b.Do(func() error { return nil }); err != nil {
+			t.Fatalf("probe %d error = %v", i, err)
+		}
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v after successful probes, want %v", got, Closed)
+	}
+
+	want := []State{Open, HalfOpen, Closed}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestBreaker_TripsOnSlowCalls(t *testing.T) {
+	b, clock := newTestBreaker(Options{
+		Window:                time.Minute,
+		MinRequests:           2,
+		SlowCallDuration:      time.Second,
+		SlowCallRateThreshold: 1,
+		OpenDuration:          time.Second,
+	})
+	for i := 0; i < 2; i++ {
+		_ = b.Do(func() error {
+			clock.now = clock.now.Add(2 * time.Second)
+			return nil
+		})
+	}
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v, want %v", got, Open)
+	}
+}
+
+func TestBreaker_FailedProbeReopens(t *testing.T) {
+	b, clock := newTestBreaker(Options{
+		Window:               time.Minute,
+		MinRequests:          1,
+		FailureRateThreshold: 1,
+		OpenDuration:         time.Second,
+		HalfOpenProbes:       1,
+	})
+	_ = b.Do(func() error { return errCall })
+	clock.now = clock.now.Add(time.Second)
+
+	probing := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = b.Do(func() error {
+			close(probing)
+			<-done
+			return errCall
+		})
+	}()
+	<-probing
+	if err := b.Do(func() error { return nil }); !errors.Is(err, ErrOpen) {
+		t.Errorf("Do() error = %v while the probe is in flight, want %v", err, ErrOpen)
+	}
+	done <- struct{}{}
+	<-done
+
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v after a failed probe, want %v", got, Open)
+	}
+}
+
+func TestBreaker_IgnoresNonFailures(t *testing.T) {
+	errNotFound := errors.New("not found")
+	b, _ := newTestBreaker(Options{
+		Window:               time.Minute,
+		MinRequests:          1,
+		FailureRateThreshold: 1,
+		OpenDuration:         time.Second,
+		IsFailure: func(err error) bool {
+			return err != nil && !errors.Is(err, errNotFound)
+		},
+	})
+	if err := b.Do(func() error { return errNotFound }); !errors.Is(err, errNotFound) {
+		t.Fatalf("Do() error = %v, want %v", err, errNotFound)
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v, want %v", got, Closed)
+	}
+}
@@ -0,0 +1,167 @@
+// Package healthcheck provides the healthz.Checkers of the webhook server, and
+// the probes of its dependencies that are exported as metrics instead.
+package healthcheck
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	servingCertExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_serving_certificate_expiration_timestamp_seconds",
+		Help: "Expiration time of the serving certificate of the webhook server, as a Unix timestamp.",
+	})
+	apiserverReachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_apiserver_reachable",
+		Help: "Whether the /readyz endpoint of the API server answered the last probe: 1 if it did, 0 otherwise.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(servingCertExpiry, apiserverReachable)
+}
+
+// APIServerProbe polls the /readyz endpoint of the API server and exports
+// whether it answers. Its Check fails no aggregate readiness on purpose: an API
+// server outage reaches every replica at once, and the webhook degrades
+// gracefully through its fallback tier and cached counts rather than by
+// dropping out of its Service. Serve it with Named.
+type APIServerProbe struct {
+	client   rest.Interface
+	timeout  time.Duration
+	interval time.Duration
+
+	mu sync.Mutex
+	// reachable is the result of the last probe, nil before the first one,
+	// and err its error.
+	reachable *bool
+	err       error
+}
+
+// NewAPIServerProbe creates an APIServerProbe that probes the API server
+// through client every interval, waiting at most timeout per probe.
+func NewAPIServerProbe(client rest.Interface, timeout, interval time.Duration) *APIServerProbe {
+	return &APIServerProbe{client: client, timeout: timeout, interval: interval}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// probes the API server it talks to.
+func (p *APIServerProbe) NeedLeaderElection() bool {
+	return false
+}
+
+// Start probes the API server until ctx is done.
+func (p *APIServerProbe) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, p.probe, p.interval)
+	return nil
+}
+
+func (p *APIServerProbe) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	err := p.client.Get().AbsPath("/readyz").Do(ctx).Error()
+	reachable := err == nil
+	if reachable {
+		apiserverReachable.Set(1)
+	} else {
+		apiserverReachable.Set(0)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.reachable != nil && *p.reachable == reachable:
+	case reachable:
+		klog.Info("API server is reachable")
+	default:
+		klog.Warningf("API server is not reachable: %v", err)
+	}
+	p.reachable, p.err = &reachable, err
+}
+
+// Check is a healthz.Checker that fails while the last probe of the API server
+// failed.
+func (p *APIServerProbe) Check(_ *http.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.reachable == nil:
+		return errors.New("API server was not probed yet")
+	case !*p.reachable:
+		return fmt.Errorf("API server is not reachable: %w", p.err)
+	}
+	return nil
+}
+
+// Named returns a healthz.Checker that runs check only when it is requested by
+// name, as /readyz/<name>, and passes within the aggregate /readyz. It reports
+// a dependency whose failure reaches every replica at once, where failing
+// readiness would take every endpoint out of the Service.
+func Named(name string, check healthz.Checker) healthz.Checker {
+	return func(req *http.Request) error {
+		if path.Clean("/"+req.URL.Path) != "/"+name {
+			return nil
+		}
+		return check(req)
+	}
+}
+
+// ServingCert returns a healthz.Checker that passes while the certificate in
+// certFile is valid. It exports the expiration time of the certificate and
+// warns once it is closer than warnBefore, so that a renewal can happen before
+// every replica turns unready at once.
+func ServingCert(certFile string, warnBefore time.Duration) healthz.Checker {
+	return ServingCertFrom(func() (*x509.Certificate, error) {
+		return readCertificate(certFile)
+	}, warnBefore)
+}
+
+// ServingCertFrom is ServingCert for a certificate returned by leaf, such as
+// one served from memory rather than from a file.
+func ServingCertFrom(leaf func() (*x509.Certificate, error), warnBefore time.Duration) healthz.Checker {
+	return func(_ *http.Request) error {
+		cert, err := leaf()
+		if err != nil {
+			return err
+		}
+		servingCertExpiry.Set(float64(cert.NotAfter.Unix()))
+
+		now := time.Now()
+		switch {
+		case now.Before(cert.NotBefore):
+			return fmt.Errorf("serving certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			return fmt.Errorf("serving certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+		case cert.NotAfter.Sub(now) < warnBefore:
+			klog.Warningf("Serving certificate %s expires in %s", cert.Subject.CommonName, cert.NotAfter.Sub(now).Round(time.Minute))
+		}
+		return nil
+	}
+}
+
+func readCertificate(certFile string) (*x509.Certificate, error) {
+	raw, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serving certificate: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", certFile)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,135 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func writeCert(t *testing.T, notBefore, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "k8s-webhook"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tls.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	return path
+}
+
+func TestServingCert(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "valid", path: writeCert(t, now.Add(-time.Hour), now.Add(time.Hour))},
+		{name: "expired", path: writeCert(t, now.Add(-2*time.Hour), now.Add(-time.Hour)), wantErr: true},
+		{name: "not yet valid", path: writeCert(t, now.Add(time.Hour), now.Add(2*time.Hour)), wantErr: true},
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing.crt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ServingCert(tt.path, 24*time.Hour)(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ServingCert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIServerProbe(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" || !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	probe := NewAPIServerProbe(client.Discovery().RESTClient(), time.Second, time.Minute)
+	if err := probe.Check(nil); err == nil {
+		t.Errorf("Check() = nil before the first probe")
+	}
+	probe.probe(context.Background())
+	if got := gaugeValue(t, apiserverReachable); got != 1 {
+		t.Errorf("webhook_apiserver_reachable = %v while the API server is ready, want 1", got)
+	}
+	if err := probe.Check(nil); err != nil {
+		t.Errorf("Check() = %v while the API server is ready", err)
+	}
+	healthy = false
+	probe.probe(context.Background())
+	if got := gaugeValue(t, apiserverReachable); got != 0 {
+		t.Errorf("webhook_apiserver_reachable = %v while the API server is not ready, want 0", got)
+	}
+	if err := probe.Check(nil); err == nil {
+		t.Errorf("Check() = nil while the API server is not ready")
+	}
+}
+
+func TestNamed(t *testing.T) {
+	failing := func(*http.Request) error { return errors.New("down") }
+	handler := &healthz.Handler{Checks: map[string]healthz.Checker{
+		"apiserver": Named("apiserver", failing),
+		"ping":      healthz.Ping,
+	}}
+	for path, want := range map[string]int{
+		"/":           http.StatusOK,
+		"/ping":       http.StatusOK,
+		"/apiserver":  http.StatusInternalServerError,
+		"/apiserver/": http.StatusInternalServerError,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatalf("Failed to read gauge: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
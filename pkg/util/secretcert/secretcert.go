@@ -0,0 +1,209 @@
+// Package secretcert serves the webhook certificate straight from a Secret, so
+// that a renewal is picked up as soon as the Secret changes instead of after the
+// kubelet syncs a mounted volume.
+package secretcert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var loadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "webhook_serving_certificate_secret_load_errors_total",
+	Help: "Number of times the serving certificate Secret was missing or malformed.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(loadErrors)
+}
+
+// Source keeps the last good certificate of the Secret Namespace/Name. It is
+// safe for concurrent use.
+type Source struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	err  error
+	// synced tells whether the watch of the Secret has synced, nil until it
+	// starts.
+	synced cache.InformerSynced
+}
+
+var _ manager.LeaderElectionRunnable = &Source{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// serves the webhook.
+func (s *Source) NeedLeaderElection() bool {
+	return false
+}
+
+// GetCertificate implements tls.Config.GetCertificate with the last good
+// certificate of the Secret.
+func (s *Source) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, s.lastError()
+	}
+	return s.cert, nil
+}
+
+// Leaf returns the parsed last good certificate, or the last load error if
+// there is none.
+func (s *Source) Leaf() (*x509.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, s.lastError()
+	}
+	return s.cert.Leaf, nil
+}
+
+func (s *Source) lastError() error {
+	if s.err != nil {
+		return s.err
+	}
+	return fmt.Errorf("no certificate loaded from Secret %s/%s yet", s.Namespace, s.Name)
+}
+
+// CABundle returns the ca.crt of the Secret, or its tls.crt if it has none, for
+// injection into the webhook configurations.
+func (s *Source) CABundle(ctx context.Context) ([]byte, error) {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if caBundle := secret.Data["ca.crt"]; len(caBundle) > 0 {
+		return caBundle, nil
+	}
+	if len(secret.Data[corev1.TLSCertKey]) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has neither ca.crt nor %s", s.Namespace, s.Name, corev1.TLSCertKey)
+	}
+	return secret.Data[corev1.TLSCertKey], nil
+}
+
+// Load gets the Secret once, so that a certificate is served right from the
+// start. A missing or malformed Secret is reported and kept for later.
+func (s *Source) Load(ctx context.Context) error {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("failed to get Secret %s/%s: %w", s.Namespace, s.Name, err)
+		s.fail(err)
+		return err
+	}
+	return s.update(secret)
+}
+
+// Start watches the Secret until ctx is done.
+func (s *Source) Start(ctx context.Context) error {
+	klog.Infof("Watching serving certificate Secret %s/%s", s.Namespace, s.Name)
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Client, 0,
+		informers.WithNamespace(s.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.Name).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				_ = s.update(secret)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				_ = s.update(secret)
+			}
+		},
+		DeleteFunc: func(interface{}) {
+			s.fail(fmt.Errorf("secret %s/%s was deleted", s.Namespace, s.Name))
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.synced = informer.HasSynced
+	s.mu.Unlock()
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+	return nil
+}
+
+// SyncCheck is a healthz.Checker that fails until the watch of the Secret has
+// synced, so that a renewal made while the replica starts is not missed.
+func (s *Source) SyncCheck(_ *http.Request) error {
+	s.mu.RLock()
+	synced := s.synced
+	s.mu.RUnlock()
+	if synced == nil || !synced() {
+		return fmt.Errorf("watch of Secret %s/%s has not synced", s.Namespace, s.Name)
+	}
+	return nil
+}
+
+// update loads the certificate of secret, keeping the previous one if it is
+// malformed.
+func (s *Source) update(secret *corev1.Secret) error {
+	cert, err := parse(secret)
+	if err != nil {
+		err = fmt.Errorf("secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		s.fail(err)
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cert == nil || !s.cert.Leaf.Equal(cert.Leaf) {
+		klog.Infof("Loaded serving certificate from Secret %s/%s, valid until %s", secret.Namespace, secret.Name, cert.Leaf.NotAfter)
+	}
+	s.cert, s.err = cert, nil
+	return nil
+}
+
+func (s *Source) fail(err error) {
+	loadErrors.Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	if s.cert != nil {
+		klog.Errorf("Keeping the last good serving certificate: %v", err)
+		return
+	}
+	klog.Errorf("No serving certificate to serve: %v", err)
+}
+
+func parse(secret *corev1.Secret) (*tls.Certificate, error) {
+	certPEM, keyPEM := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return nil, errors.New("missing " + corev1.TLSCertKey + " or " + corev1.TLSPrivateKeyKey)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
@@ -0,0 +1,138 @@
+package secretcert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTLSSecret(t *testing.T, commonName string) *corev1.Secret {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "k8s-webhook-template", Name: "k8s-webhook-template-tls"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func servedName(t *testing.T, s *Source) string {
+	t.Helper()
+	cert, err := s.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestSource_Load(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := &Source{Client: client, Namespace: "k8s-webhook-template", Name: "k8s-webhook-template-tls"}
+
+	if err := s.Load(context.TODO()); err == nil {
+		t.Fatal("Load() error = nil for a missing Secret")
+	}
+	if _, err := s.GetCertificate(nil); err == nil {
+		t.Fatal("GetCertificate() error = nil before a certificate was loaded")
+	}
+
+	if _, err := client.CoreV1().Secrets("k8s-webhook-template").Create(context.TODO(), newTLSSecret(t, "first"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(context.TODO()); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := servedName(t, s); got != "first" {
+		t.Errorf("served certificate = %s, want first", got)
+	}
+	if leaf, err := s.Leaf(); err != nil || leaf.Subject.CommonName != "first" {
+		t.Errorf("Leaf() = %v, %v; want first", leaf, err)
+	}
+}
+
+func TestSource_KeepsLastGoodCertificate(t *testing.T) {
+	s := &Source{Namespace: "k8s-webhook-template", Name: "k8s-webhook-template-tls"}
+	if err := s.update(newTLSSecret(t, "good")); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+
+	malformed := newTLSSecret(t, "bad")
+	malformed.Data[corev1.TLSPrivateKeyKey] = newTLSSecret(t, "other").Data[corev1.TLSPrivateKeyKey]
+	if err := s.update(malformed); err == nil {
+		t.Fatal("update() error = nil for a key that does not match the certificate")
+	}
+	incomplete := newTLSSecret(t, "bad")
+	delete(incomplete.Data, corev1.TLSCertKey)
+	if err := s.update(incomplete); err == nil {
+		t.Fatal("update() error = nil for a Secret without tls.crt")
+	}
+	if got := servedName(t, s); got != "good" {
+		t.Errorf("served certificate = %s, want the last good one", got)
+	}
+}
+
+func TestSource_Start(t *testing.T) {
+	secret := newTLSSecret(t, "first")
+	client := fake.NewSimpleClientset(secret)
+	s := &Source{Client: client, Namespace: "k8s-webhook-template", Name: "k8s-webhook-template-tls"}
+	if err := s.SyncCheck(nil); err == nil {
+		t.Errorf("SyncCheck() = nil before the watch started")
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() { _ = s.Start(ctx) }()
+
+	waitForName := func(want string) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			cert, err := s.GetCertificate(nil)
+			return err == nil && cert.Leaf.Subject.CommonName == want, nil
+		})
+		if err != nil {
+			t.Fatalf("certificate %s was not served: %v", want, err)
+		}
+	}
+	waitForName("first")
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return s.SyncCheck(nil) == nil, nil
+	}); err != nil {
+		t.Errorf("SyncCheck() did not pass once the watch started: %v", err)
+	}
+
+	if _, err := client.CoreV1().Secrets("k8s-webhook-template").Update(ctx, newTLSSecret(t, "second"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForName("second")
+}
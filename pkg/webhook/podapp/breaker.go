@@ -0,0 +1,53 @@
+package podapp
+
+import (
+	"context"
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
+)
+
+// NewAPIServerBreaker creates the circuit breaker for the API server calls made
+// by MutatingAdmission. Only errors that hint at a degraded API server count as
+// failures, and every transition is logged and exported as a metric.
+func NewAPIServerBreaker(opts circuitbreaker.Options) *circuitbreaker.Breaker {
+	opts.IsFailure = isAPIServerFailure
+	opts.OnStateChange = func(from, to circuitbreaker.State) {
+		klog.Warningf("API server circuit breaker changed from %s to %s", from, to)
+		apiserverBreakerState.Set(float64(to))
+	}
+	return circuitbreaker.New(opts)
+}
+
+// isAPIServerFailure reports whether err means the API server is unhealthy, as
+// opposed to an answer about the requested object.
+func isAPIServerFailure(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.As(err, new(*callerDoneError)):
+		// The caller went away, which says nothing about the API server.
+		return false
+	case apierrors.IsNotFound(err), apierrors.IsAlreadyExists(err), apierrors.IsConflict(err),
+		apierrors.IsForbidden(err), apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return false
+	}
+	return true
+}
+
+// callerDoneError is the error of an API server call whose caller was done
+// first, such as a decision that ran out of its budget.
+type callerDoneError struct {
+	err error
+}
+
+func (e *callerDoneError) Error() string {
+	return e.err.Error()
+}
+
+func (e *callerDoneError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,160 @@
+package podapp
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// maxCachedWorkloads bounds the number of workloads kept in a countsCache.
+const maxCachedWorkloads = 4096
+
+// workloadCounts is the last tier distribution observed for a workload,
+// together with the strategy it was observed under.
+type workloadCounts struct {
+	Strategy   UserStrategy
+	OnDemand   int
+	Spot       int
+	ObservedAt time.Time
+	// Pods are the pods of the workload, trimmed to their node and node
+	// affinity, by which the zone-aware floor and the spot diversification
+	// count them. Only kept for the strategies that enable either.
+	Pods []corev1.Pod
+}
+
+// countsCache remembers the last known counts of every workload, so that pods
+// can still be placed while the API server is unavailable.
+type countsCache struct {
+	mu      sync.Mutex
+	entries map[string]*workloadCounts
+	now     func() time.Time
+}
+
+func newCountsCache() *countsCache {
+	return &countsCache{entries: map[string]*workloadCounts{}, now: time.Now}
+}
+
+// observe replaces the counts of key with a fresh observation of the pods of
+// podList.
+func (c *countsCache) observe(key string, strategy *UserStrategy, onDemand, spot int, podList *corev1.PodList) {
+	var pods []corev1.Pod
+	if strategy.ZoneAwareFloor || strategy.SpotDiversification {
+		pods = make([]corev1.Pod, 0, len(podList.Items))
+		for i := range podList.Items {
+			pods = append(pods, trimPod(&podList.Items[i]))
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedWorkloads {
+		c.evictOldestLocked()
+	}
+	c.entries[key] = &workloadCounts{
+		Strategy:   *strategy,
+		OnDemand:   onDemand,
+		Spot:       spot,
+		ObservedAt: c.now(),
+		Pods:       pods,
+	}
+}
+
+// record accounts a pod that was just placed on tier, which the next List may
+// not return yet, and returns the updated counts.
+func (c *countsCache) record(key string, tier string, placed *corev1.Pod) (workloadCounts, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return workloadCounts{}, false
+	}
+	entry.add(tier, placed)
+	return *entry, true
+}
+
+// get returns a copy of the counts of key and whether they are younger than staleness.
+func (c *countsCache) get(key string, staleness time.Duration) (workloadCounts, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return workloadCounts{}, false, false
+	}
+	return *entry, true, c.now().Sub(entry.ObservedAt) <= staleness
+}
+
+// decide places a pod with place from the cached counts of key, accounts the
+// placed pod it returns and returns the updated counts. Concurrent decisions of
+// a workload are serialized. It reports false when there are no counts younger
+// than staleness.
+func (c *countsCache) decide(key string, staleness time.Duration, place func(workloadCounts) (Placement, *corev1.Pod)) (workloadCounts, Placement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return workloadCounts{}, Placement{}, false
+	}
+	if c.now().Sub(entry.ObservedAt) > staleness {
+		return *entry, Placement{}, false
+	}
+	placement, placed := place(*entry)
+	entry.add(placement.Tier, placed)
+	return *entry, placement, true
+}
+
+// list returns a copy of the counts of every workload by key.
+func (c *countsCache) list() map[string]workloadCounts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make(map[string]workloadCounts, len(c.entries))
+	for key, entry := range c.entries {
+		entries[key] = *entry
+	}
+	return entries
+}
+
+func (c *countsCache) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if oldestKey == "" || entry.ObservedAt.Before(oldest) {
+			oldestKey, oldest = key, entry.ObservedAt
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+func (w *workloadCounts) add(tier string, placed *corev1.Pod) {
+	switch tier {
+	case OnDemandValue:
+		w.OnDemand++
+	case SpotValue:
+		w.Spot++
+	}
+	if w.Pods != nil && placed != nil {
+		w.Pods = append(w.Pods, trimPod(placed))
+	}
+}
+
+// trimPod returns the node and the node affinity of pod, all the zone-aware
+// floor and the spot diversification count it by.
+func trimPod(pod *corev1.Pod) corev1.Pod {
+	return corev1.Pod{Spec: corev1.PodSpec{NodeName: pod.Spec.NodeName, Affinity: pod.Spec.Affinity}}
+}
+
+// without returns the counts before a pod was accounted on tier.
+func (w workloadCounts) without(tier string) workloadCounts {
+	switch tier {
+	case OnDemandValue:
+		w.OnDemand--
+	case SpotValue:
+		w.Spot--
+	}
+	return w
+}
@@ -0,0 +1,275 @@
+package podapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// recentDecisionsSize is the number of decisions kept for the debug handler.
+const recentDecisionsSize = 256
+
+// DebugPathPrefix is the path under which DebugHandler serves.
+const DebugPathPrefix = "/debug/webhook/"
+
+// tierOther groups the nodes that carry no capacity label.
+const tierOther = "other"
+
+// decisionRecord is a decision of the handler, kept for troubleshooting.
+type decisionRecord struct {
+	Time      time.Time `json:"time"`
+	UID       types.UID `json:"uid"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Decision  string    `json:"decision"`
+}
+
+// decisionLog is a ring of the most recent decisions.
+type decisionLog struct {
+	mu      sync.Mutex
+	records []decisionRecord
+	next    int
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{records: make([]decisionRecord, 0, size)}
+}
+
+func (l *decisionLog) add(r decisionRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.records) < cap(l.records) {
+		l.records = append(l.records, r)
+		return
+	}
+	l.records[l.next] = r
+	l.next = (l.next + 1) % len(l.records)
+}
+
+// list returns the decisions, newest first.
+func (l *decisionLog) list() []decisionRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	records := make([]decisionRecord, 0, len(l.records))
+	for i := len(l.records) - 1; i >= 0; i-- {
+		records = append(records, l.records[(l.next+i)%len(l.records)])
+	}
+	return records
+}
+
+func (a *MutatingAdmission) recordDecision(uid types.UID, namespace string, pod *corev1.Pod, decision string) {
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+	a.recent.add(decisionRecord{Time: time.Now(), UID: uid, Namespace: namespace, Pod: name, Decision: decision})
+}
+
+type workloadStatus struct {
+	Key            string    `json:"key"`
+	Workload       string    `json:"workload"`
+	OnDemand       int       `json:"onDemand"`
+	Spot           int       `json:"spot"`
+	LowWaterLevel  int       `json:"lowWaterLevel"`
+	HighWaterLevel int       `json:"highWaterLevel"`
+	ObservedAt     time.Time `json:"observedAt"`
+}
+
+type lockStatus struct {
+	Key        string    `json:"key"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	Age        string    `json:"age"`
+}
+
+type tierInventory struct {
+	Tier        string              `json:"tier"`
+	Nodes       int                 `json:"nodes"`
+	Allocatable corev1.ResourceList `json:"allocatable"`
+	Requested   corev1.ResourceList `json:"requested"`
+}
+
+// DebugHandler serves, under DebugPathPrefix, the state of the handler as JSON,
+// or as a table with ?format=table:
+//   - workloads: the tier counts of every workload as last seen,
+//   - locks: the workload Leases held by this replica and their age,
+//   - nodes: the allocatable and requested resources of the nodes of each tier,
+//   - decisions: the most recent decisions, newest first.
+func (a *MutatingAdmission) DebugHandler() http.Handler {
+	a.initOnce.Do(a.init)
+	mux := http.NewServeMux()
+	mux.HandleFunc(DebugPathPrefix+"workloads", a.serveWorkloads)
+	mux.HandleFunc(DebugPathPrefix+"locks", a.serveLocks)
+	mux.HandleFunc(DebugPathPrefix+"nodes", a.serveNodes)
+	mux.HandleFunc(DebugPathPrefix+"decisions", a.serveDecisions)
+	return mux
+}
+
+func (a *MutatingAdmission) serveWorkloads(w http.ResponseWriter, r *http.Request) {
+	entries := a.counts.list()
+	workloads := make([]workloadStatus, 0, len(entries))
+	for key, c := range entries {
+		workloads = append(workloads, workloadStatus{
+			Key:            key,
+			Workload:       c.Strategy.Workload,
+			OnDemand:       c.OnDemand,
+			Spot:           c.Spot,
+			LowWaterLevel:  c.Strategy.LowWaterLevel,
+			HighWaterLevel: c.Strategy.HighWaterLevel,
+			ObservedAt:     c.ObservedAt,
+		})
+	}
+	sort.Slice(workloads, func(i, j int) bool { return workloads[i].Key < workloads[j].Key })
+	writeDebug(w, r, workloads, []string{"KEY", "WORKLOAD", "ON-DEMAND", "SPOT", "LOW", "HIGH", "OBSERVED"}, func(add func(...interface{})) {
+		for _, s := range workloads {
+			add(s.Key, s.Workload, s.OnDemand, s.Spot, s.LowWaterLevel, s.HighWaterLevel, s.ObservedAt.Format(time.RFC3339))
+		}
+	})
+}
+
+func (a *MutatingAdmission) serveLocks(w http.ResponseWriter, r *http.Request) {
+	leases := a.held.list()
+	locks := make([]lockStatus, 0, len(leases))
+	for _, key := range sortedKeys(leases) {
+		acquiredAt := leases[key].AcquiredAt
+		locks = append(locks, lockStatus{Key: key, AcquiredAt: acquiredAt, Age: time.Since(acquiredAt).Round(time.Millisecond).String()})
+	}
+	writeDebug(w, r, locks, []string{"KEY", "ACQUIRED", "AGE"}, func(add func(...interface{})) {
+		for _, l := range locks {
+			add(l.Key, l.AcquiredAt.Format(time.RFC3339), l.Age)
+		}
+	})
+}
+
+func (a *MutatingAdmission) serveNodes(w http.ResponseWriter, r *http.Request) {
+	inventory, err := a.nodeInventory(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeDebug(w, r, inventory, []string{"TIER", "NODES", "CPU", "MEMORY"}, func(add func(...interface{})) {
+		for _, t := range inventory {
+			add(t.Tier, t.Nodes,
+				usage(t.Requested, t.Allocatable, corev1.ResourceCPU),
+				usage(t.Requested, t.Allocatable, corev1.ResourceMemory))
+		}
+	})
+}
+
+func (a *MutatingAdmission) serveDecisions(w http.ResponseWriter, r *http.Request) {
+	decisions := a.recent.list()
+	writeDebug(w, r, decisions, []string{"TIME", "UID", "NAMESPACE", "POD", "DECISION"}, func(add func(...interface{})) {
+		for _, d := range decisions {
+			add(d.Time.Format(time.RFC3339), d.UID, d.Namespace, d.Pod, d.Decision)
+		}
+	})
+}
+
+// nodeInventory sums the allocatable resources of the nodes of every tier and
+// the requests of the pods running on them.
+func (a *MutatingAdmission) nodeInventory(r *http.Request) ([]tierInventory, error) {
+	nodes, err := a.Client.CoreV1().Nodes().List(r.Context(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := a.Client.CoreV1().Pods(metav1.NamespaceAll).List(r.Context(), metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	policy := a.currentPolicy()
+	tiers := map[string]*tierInventory{}
+	nodeTier := map[string]*tierInventory{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		tier := tierOther
+		for _, name := range []string{OnDemandValue, SpotValue} {
+			if spec := policy.Tiers[name]; node.Labels[spec.NodeLabelKey] == spec.NodeLabelValue {
+				tier = name
+				break
+			}
+		}
+		inv, ok := tiers[tier]
+		if !ok {
+			inv = &tierInventory{Tier: tier, Allocatable: corev1.ResourceList{}, Requested: corev1.ResourceList{}}
+			tiers[tier] = inv
+		}
+		inv.Nodes++
+		addResources(inv.Allocatable, node.Status.Allocatable)
+		nodeTier[node.Name] = inv
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		inv, ok := nodeTier[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			addResources(inv.Requested, c.Resources.Requests)
+		}
+	}
+
+	inventory := make([]tierInventory, 0, len(tiers))
+	for _, inv := range tiers {
+		inventory = append(inventory, *inv)
+	}
+	sort.Slice(inventory, func(i, j int) bool { return inventory[i].Tier < inventory[j].Tier })
+	return inventory, nil
+}
+
+func addResources(total, add corev1.ResourceList) {
+	for name, q := range add {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+func usage(requested, allocatable corev1.ResourceList, name corev1.ResourceName) string {
+	req, alloc := requested[name], allocatable[name]
+	if alloc.IsZero() {
+		return fmt.Sprintf("%s/%s", req.String(), alloc.String())
+	}
+	return fmt.Sprintf("%s/%s (%d%%)", req.String(), alloc.String(), req.MilliValue()*100/alloc.MilliValue())
+}
+
+// writeDebug writes v as JSON, or as a table of header and the rows passed to
+// add when the request asks for ?format=table.
+func writeDebug(w http.ResponseWriter, r *http.Request, v interface{}, header []string, rows func(add func(...interface{}))) {
+	if r.URL.Query().Get("format") != "table" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			klog.Errorf("Failed to write debug response: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	rows(func(values ...interface{}) {
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = fmt.Sprint(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	})
+	if err := tw.Flush(); err != nil {
+		klog.Errorf("Failed to write debug response: %v", err)
+	}
+}
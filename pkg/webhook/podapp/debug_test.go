@@ -0,0 +1,95 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestDecisionLog_NewestFirst(t *testing.T) {
+	l := newDecisionLog(3)
+	for i := 0; i < 5; i++ {
+		l.add(decisionRecord{Pod: fmt.Sprintf("pod-%d", i)})
+	}
+	var got []string
+	for _, r := range l.list() {
+		got = append(got, r.Pod)
+	}
+	if want := "pod-4,pod-3,pod-2"; strings.Join(got, ",") != want {
+		t.Errorf("list() = %v, want %s", got, want)
+	}
+}
+
+func TestMutatingAdmission_DebugHandler(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	running := newOnDemandPod(pod, "existing-0")
+	running.Spec.NodeName = "node-a"
+	running.Spec.Containers = []corev1.Container{{
+		Name:      "app",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+	}}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{OnDemandNodeLabelKey: OnDemandValue}},
+		Status:     corev1.NodeStatus{Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+	}
+	m := &MutatingAdmission{
+		Decoder: &fakeMutationDecoder{obj: pod},
+		Client:  fake.NewSimpleClientset(deploy, rs, running, node),
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UID:       "uid-1",
+		Namespace: pod.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		m.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DebugPathPrefix+path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	var workloads []workloadStatus
+	if err := json.Unmarshal([]byte(get("workloads")), &workloads); err != nil {
+		t.Fatalf("Failed to unmarshal workloads: %v", err)
+	}
+	if len(workloads) != 1 || workloads[0].Workload != deploy.Name || workloads[0].OnDemand != 2 {
+		t.Errorf("workloads = %+v, want %s with 2 on-demand pods", workloads, deploy.Name)
+	}
+
+	var decisions []decisionRecord
+	if err := json.Unmarshal([]byte(get("decisions")), &decisions); err != nil {
+		t.Fatalf("Failed to unmarshal decisions: %v", err)
+	}
+	if len(decisions) != 1 || decisions[0].UID != "uid-1" || decisions[0].Decision != "on-demand by below-low-water" {
+		t.Errorf("decisions = %+v, want the on-demand placement of uid-1", decisions)
+	}
+
+	table := get("nodes?format=table")
+	if !strings.Contains(table, "on-demand") || !strings.Contains(table, "500m/2 (25%)") {
+		t.Errorf("nodes table = %q, want 500m of 2 CPUs requested on on-demand", table)
+	}
+}
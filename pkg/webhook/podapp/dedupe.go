@@ -0,0 +1,92 @@
+package podapp
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// decisionCache remembers the responses of recent admissions by request UID, so
+// that a retried request gets the identical patch without being counted again.
+// It holds at most size entries and evicts the least recently used one first.
+//
+// The cache is local to the replica. A retry that the Service sends to another
+// replica is counted again there, and may be placed on another tier.
+type decisionCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[types.UID]*list.Element
+}
+
+type decisionEntry struct {
+	uid       types.UID
+	resp      admission.Response
+	expiresAt time.Time
+}
+
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[types.UID]*list.Element{},
+	}
+}
+
+// get returns the response cached for uid, if it has not expired.
+func (c *decisionCache) get(uid types.UID) (admission.Response, bool) {
+	if c == nil || uid == "" {
+		return admission.Response{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[uid]
+	if !ok {
+		return admission.Response{}, false
+	}
+	entry := elem.Value.(*decisionEntry)
+	if c.now().After(entry.expiresAt) {
+		c.removeLocked(elem)
+		return admission.Response{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.resp, true
+}
+
+// add caches resp for uid, evicting the least recently used entry when full.
+func (c *decisionCache) add(uid types.UID, resp admission.Response) {
+	if c == nil || uid == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if elem, ok := c.entries[uid]; ok {
+		entry := elem.Value.(*decisionEntry)
+		entry.resp, entry.expiresAt = resp, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	for c.order.Len() >= c.size {
+		c.removeLocked(c.order.Back())
+		decisionCacheEvictions.Inc()
+	}
+	c.entries[uid] = c.order.PushFront(&decisionEntry{uid: uid, resp: resp, expiresAt: expiresAt})
+	decisionCacheEntries.Inc()
+}
+
+func (c *decisionCache) removeLocked(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*decisionEntry).uid)
+	decisionCacheEntries.Dec()
+}
@@ -0,0 +1,36 @@
+package podapp
+
+import (
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestDecisionCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newDecisionCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.add("a", admission.Allowed("a"))
+	c.add("b", admission.Allowed("b"))
+	if _, ok := c.get("a"); !ok {
+		t.Fatalf("get(a) missed")
+	}
+	// "b" is now the least recently used entry and makes room for "c".
+	c.add("c", admission.Allowed("c"))
+	if _, ok := c.get("b"); ok {
+		t.Errorf("get(b) hit after eviction")
+	}
+	if resp, ok := c.get("c"); !ok || resp.Result.Message != "c" {
+		t.Errorf("get(c) = %v, %v", resp, ok)
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get(a) hit after the TTL")
+	}
+	if _, ok := c.get(""); ok {
+		t.Errorf("get() hit for an empty UID")
+	}
+}
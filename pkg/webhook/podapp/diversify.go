@@ -0,0 +1,205 @@
+package podapp
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AnnotationSpotDiversification spreads the spot pods of a workload over the
+	// instance types of the spot nodes, so that a reclaim of one instance family
+	// takes only part of them. Each spot pod prefers every instance type with a
+	// weight inversely proportional to the pods of the workload already on it.
+	AnnotationSpotDiversification string = "webhook-demo.com/spot-diversification"
+	// AnnotationSpotMaxInstanceTypeShare caps the share of the spot pods of a
+	// diversified workload on any single instance type, in percent. A spot pod
+	// that would exceed it on an instance type is kept off the spot nodes of
+	// that type, while it can still land on the nodes of other tiers.
+	AnnotationSpotMaxInstanceTypeShare string = "webhook-demo.com/spot-max-instance-type-share"
+)
+
+// InstanceTypeWeights are the weights of the preferred instance types of a
+// diversified spot pod, by instance type.
+type InstanceTypeWeights map[string]int32
+
+// GetInstanceTypeShare returns the share in percent of the annotation key, or
+// 0, no cap, if it is missing or not between 1 and 100.
+func GetInstanceTypeShare(annotations map[string]string, key string) int {
+	value, ok := annotations[key]
+	if !ok {
+		return 0
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 1 || v > 100 {
+		return 0
+	}
+	return v
+}
+
+// diversifyInstanceTypes returns the weight of every instance type of the spot
+// nodes for the next spot pod of the workload of strategy, given its pods in
+// podList, and the instance types that pod must avoid to keep the share of
+// each under the cap of strategy. It returns no weights if the instance types
+// cannot be discovered with labels.
+func (a *MutatingAdmission) diversifyInstanceTypes(ctx context.Context, strategy *UserStrategy, podList *corev1.PodList, labels tierLabelsFunc) (InstanceTypeWeights, []string) {
+	types, nodeTypes, err := labels(a.types, SpotValue)
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to discover the instance types of the spot nodes, not diversifying")
+		return nil, nil
+	}
+	if len(types) < 2 {
+		klog.FromContext(ctx).V(2).Info("Fewer than two instance types on the spot nodes, not diversifying", "instanceTypes", types)
+		return nil, nil
+	}
+
+	counts := map[string]int{}
+	total := 0
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if a.isOnDemandPod(pod) {
+			continue
+		}
+		instanceType := nodeTypes[pod.Spec.NodeName]
+		if pod.Spec.NodeName == "" {
+			instanceType = preferredInstanceType(pod)
+		}
+		if instanceType == "" {
+			continue
+		}
+		counts[instanceType]++
+		total++
+	}
+
+	lowest := counts[types[0]]
+	for _, t := range types {
+		lowest = min(lowest, counts[t])
+	}
+	weights := InstanceTypeWeights{}
+	var capped []string
+	for _, t := range types {
+		if strategy.SpotMaxInstanceTypeShare > 0 && (counts[t]+1)*100 > strategy.SpotMaxInstanceTypeShare*(total+1) {
+			capped = append(capped, t)
+			continue
+		}
+		weights[t] = max(int32(100*(lowest+1)/(counts[t]+1)), 1)
+	}
+	if len(weights) == 0 {
+		// Every instance type is at its cap, which only happens while the
+		// workload has few spot pods: prefer them all alike.
+		for _, t := range types {
+			weights[t] = 100
+		}
+		capped = nil
+	}
+	return weights, capped
+}
+
+// preferredInstanceType returns the instance type a spot pod that is not
+// scheduled yet prefers the most, if any.
+func preferredInstanceType(pod *corev1.Pod) string {
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil {
+		return ""
+	}
+	instanceType, weight := "", int32(0)
+	for _, term := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		for _, expr := range term.Preference.MatchExpressions {
+			if expr.Key == corev1.LabelInstanceTypeStable && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 && term.Weight > weight {
+				instanceType, weight = expr.Values[0], term.Weight
+			}
+		}
+	}
+	return instanceType
+}
+
+// ensureInstanceTypeAffinity makes pod prefer the instance types of weights
+// and keeps it off the spot nodes of the capped instance types.
+func (a *MutatingAdmission) ensureInstanceTypeAffinity(pod *corev1.Pod, weights InstanceTypeWeights, capped []string) {
+	if len(weights) == 0 {
+		return
+	}
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	tier := a.currentPolicy().Tiers[SpotValue]
+	types := make([]string, 0, len(weights))
+	for t := range weights {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		// The preference holds on the spot nodes only: the weights are
+		// meaningless on the nodes of an instance type of another tier.
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.PreferredSchedulingTerm{
+			Weight: weights[t],
+			Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: tier.NodeLabelKey, Operator: corev1.NodeSelectorOpIn, Values: []string{tier.NodeLabelValue}},
+				{Key: corev1.LabelInstanceTypeStable, Operator: corev1.NodeSelectorOpIn, Values: []string{t}},
+			}},
+		})
+	}
+	if len(capped) == 0 {
+		return
+	}
+
+	// Node selector terms are ORed: the pod may land on any node outside the
+	// spot tier, or on a spot node of an instance type that is not capped.
+	terms := []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: tier.NodeLabelKey, Operator: corev1.NodeSelectorOpNotIn, Values: []string{tier.NodeLabelValue}},
+		}},
+		{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: corev1.LabelInstanceTypeStable, Operator: corev1.NodeSelectorOpNotIn, Values: capped},
+		}},
+	}
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = terms
+		return
+	}
+	// The terms of the user still apply: AND them with ours.
+	var merged []corev1.NodeSelectorTerm
+	for _, user := range required.NodeSelectorTerms {
+		for _, term := range terms {
+			m := *user.DeepCopy()
+			m.MatchExpressions = append(m.MatchExpressions, term.MatchExpressions...)
+			merged = append(merged, m)
+		}
+	}
+	required.NodeSelectorTerms = merged
+}
+
+// hasInstanceTypeAffinity tells whether the node affinity of pod names instance
+// types, in which case the user diversifies it.
+func hasInstanceTypeAffinity(pod *corev1.Pod) bool {
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil {
+		return false
+	}
+	var terms []corev1.NodeSelectorTerm
+	if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		terms = append(terms, required.NodeSelectorTerms...)
+	}
+	for _, preferred := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, preferred.Preference)
+	}
+	for _, term := range terms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == corev1.LabelInstanceTypeStable || expr.Key == corev1.LabelInstanceType {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTypedNode(name, tier, instanceType string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+		OnDemandNodeLabelKey:           tier,
+		corev1.LabelInstanceTypeStable: instanceType,
+	}}}
+}
+
+func TestGetInstanceTypeShare(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{value: "50", want: 50},
+		{value: "100", want: 100},
+		{value: "0", want: 0},
+		{value: "101", want: 0},
+		{value: "half", want: 0},
+	}
+	for _, tt := range tests {
+		annotations := map[string]string{AnnotationSpotMaxInstanceTypeShare: tt.value}
+		if got := GetInstanceTypeShare(annotations, AnnotationSpotMaxInstanceTypeShare); got != tt.want {
+			t.Errorf("GetInstanceTypeShare(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	if got := GetInstanceTypeShare(nil, AnnotationSpotMaxInstanceTypeShare); got != 0 {
+		t.Errorf("GetInstanceTypeShare() without annotation = %d, want 0", got)
+	}
+}
+
+func TestMutatingAdmission_DiversifyInstanceTypes(t *testing.T) {
+	nodes := []runtime.Object{
+		newTypedNode("spot-a", SpotValue, "m5.large"),
+		newTypedNode("spot-b", SpotValue, "c5.large"),
+		newTypedNode("spot-c", SpotValue, "r5.large"),
+		newTypedNode("on-demand-d", OnDemandValue, "t3.large"),
+	}
+	_, _, template := newWorkloadObjects(nil)
+	spotPod := func(node, preferred string) corev1.Pod {
+		pod := template.DeepCopy()
+		(&MutatingAdmission{}).ensureInstanceTypeAffinity(pod, InstanceTypeWeights{preferred: 100}, nil)
+		pod.Spec.NodeName = node
+		return *pod
+	}
+	onDemandPod := func(node string) corev1.Pod {
+		pod := newOnDemandPod(template, "on-demand")
+		pod.Spec.NodeName = node
+		return *pod
+	}
+
+	tests := []struct {
+		name        string
+		nodes       []runtime.Object
+		share       int
+		pods        []corev1.Pod
+		wantWeights InstanceTypeWeights
+		wantCapped  []string
+	}{
+		{
+			name:        "empty workload",
+			nodes:       nodes,
+			wantWeights: InstanceTypeWeights{"c5.large": 100, "m5.large": 100, "r5.large": 100},
+		},
+		{
+			name:        "scheduled and pending pods weigh down their instance type",
+			nodes:       nodes,
+			pods:        []corev1.Pod{spotPod("spot-a", ""), spotPod("spot-a", ""), spotPod("", "c5.large"), onDemandPod("on-demand-d")},
+			wantWeights: InstanceTypeWeights{"c5.large": 50, "m5.large": 33, "r5.large": 100},
+		},
+		{
+			name:        "instance type at its share is capped",
+			nodes:       nodes,
+			share:       50,
+			pods:        []corev1.Pod{spotPod("spot-a", ""), spotPod("spot-a", ""), spotPod("", "c5.large")},
+			wantWeights: InstanceTypeWeights{"c5.large": 50, "r5.large": 100},
+			wantCapped:  []string{"m5.large"},
+		},
+		{
+			name:        "cap is lifted while every instance type is at it",
+			nodes:       nodes,
+			share:       30,
+			wantWeights: InstanceTypeWeights{"c5.large": 100, "m5.large": 100, "r5.large": 100},
+		},
+		{
+			name:  "single instance type",
+			nodes: []runtime.Object{newTypedNode("spot-a", SpotValue, "m5.large"), newTypedNode("spot-b", SpotValue, "m5.large")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &MutatingAdmission{Client: fake.NewSimpleClientset(tt.nodes...)}
+			a.initOnce.Do(a.init)
+			weights, capped := a.diversifyInstanceTypes(context.TODO(), &UserStrategy{SpotMaxInstanceTypeShare: tt.share}, &corev1.PodList{Items: tt.pods}, a.listedTierNodeLabels(context.TODO()))
+			if !reflect.DeepEqual(weights, tt.wantWeights) && (len(weights) != 0 || len(tt.wantWeights) != 0) {
+				t.Errorf("diversifyInstanceTypes() weights = %v, want %v", weights, tt.wantWeights)
+			}
+			if !reflect.DeepEqual(capped, tt.wantCapped) {
+				t.Errorf("diversifyInstanceTypes() capped = %v, want %v", capped, tt.wantCapped)
+			}
+		})
+	}
+}
+
+func TestMutatingAdmission_EnsureInstanceTypeAffinity(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+			{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}}}},
+		}},
+	}}}}
+	a := &MutatingAdmission{}
+	a.ensureInstanceTypeAffinity(pod, InstanceTypeWeights{"r5.large": 100, "c5.large": 50}, []string{"m5.large"})
+
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	var preferred []string
+	for _, term := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		// Each instance type is preferred among the spot nodes only.
+		exprs := term.Preference.MatchExpressions
+		if len(exprs) != 2 || exprs[0].Key != OnDemandNodeLabelKey || !reflect.DeepEqual(exprs[0].Values, []string{SpotValue}) || exprs[1].Key != corev1.LabelInstanceTypeStable {
+			t.Fatalf("preferred term = %v, want the spot tier and an instance type", exprs)
+		}
+		preferred = append(preferred, fmt.Sprintf("%s=%d", exprs[1].Values[0], term.Weight))
+	}
+	if want := []string{"c5.large=50", "r5.large=100"}; !reflect.DeepEqual(preferred, want) {
+		t.Errorf("preferred instance types = %v, want %v", preferred, want)
+	}
+	if got := preferredInstanceType(pod); got != "r5.large" {
+		t.Errorf("preferredInstanceType() = %q, want r5.large", got)
+	}
+
+	// The term of the user is ANDed with either of the cap terms.
+	terms := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 {
+		t.Fatalf("required terms = %v, want 2", terms)
+	}
+	for i, wantKey := range []string{OnDemandNodeLabelKey, corev1.LabelInstanceTypeStable} {
+		exprs := terms[i].MatchExpressions
+		if len(exprs) != 2 || exprs[0].Key != "disk" || exprs[1].Key != wantKey || exprs[1].Operator != corev1.NodeSelectorOpNotIn {
+			t.Errorf("required term %d = %v, want disk and %s NotIn", i, exprs, wantKey)
+		}
+	}
+	if a.isOnDemandPod(pod) {
+		t.Errorf("isOnDemandPod() = true for a capped spot pod")
+	}
+	if !hasInstanceTypeAffinity(pod) {
+		t.Errorf("hasInstanceTypeAffinity() = false after diversification")
+	}
+}
+
+func TestMutatingAdmission_Handle_SpotDiversification(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation:     "true",
+		AnnotationLowWaterLevel:            "1",
+		AnnotationHighWaterLevel:           "5",
+		AnnotationSpotDiversification:      "true",
+		AnnotationSpotMaxInstanceTypeShare: "50",
+	})
+	// The workload has its on-demand pod, and its spot pods on a single instance type.
+	existing := []runtime.Object{deploy, rs,
+		newTypedNode("spot-a", SpotValue, "m5.large"),
+		newTypedNode("spot-b", SpotValue, "c5.large"),
+		newOnDemandPod(pod, "existing-on-demand"),
+	}
+	for i := 0; i < 2; i++ {
+		p := pod.DeepCopy()
+		p.Name = fmt.Sprintf("existing-spot-%d", i)
+		p.Spec.NodeName = "spot-a"
+		existing = append(existing, p)
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	m := &MutatingAdmission{Decoder: &fakeMutationDecoder{obj: pod}, Client: fake.NewSimpleClientset(existing...)}
+	got := m.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !got.Allowed {
+		t.Fatalf("Handle() got.Allowed = false: %v", got.Result)
+	}
+	if tier := placedTier(t, got); tier != SpotValue {
+		t.Errorf("Handle() placed pod on %q, want %q", tier, SpotValue)
+	}
+
+	var placement Placement
+	for _, patch := range got.Patches {
+		if patch.Path == "/metadata/annotations" {
+			annotations, _ := patch.Value.(map[string]interface{})
+			raw, _ := annotations[AnnotationPlacement].(string)
+			if err := json.Unmarshal([]byte(raw), &placement); err != nil {
+				t.Fatalf("Failed to decode placement %q: %v", raw, err)
+			}
+		}
+	}
+	if want := (InstanceTypeWeights{"c5.large": 100}); !reflect.DeepEqual(placement.InstanceTypes, want) {
+		t.Errorf("placement instance types = %v, want %v", placement.InstanceTypes, want)
+	}
+	if want := []string{"m5.large"}; !reflect.DeepEqual(placement.CappedInstanceTypes, want) {
+		t.Errorf("placement capped instance types = %v, want %v", placement.CappedInstanceTypes, want)
+	}
+}
@@ -0,0 +1,59 @@
+package podapp
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the events recorded on the Deployment that owns an admitted pod.
+// The pod itself does not exist yet at admission time.
+const (
+	// EventReasonTierPlaced explains a placement made from the counts of the workload.
+	EventReasonTierPlaced = "TierPlaced"
+	// EventReasonTierFallback explains a placement on the fallback tier made
+	// without counting the workload.
+	EventReasonTierFallback = "TierFallback"
+)
+
+const (
+	strategySourceAnnotations = "Deployment annotations"
+	countsSourceListed        = "listed"
+)
+
+// recordPlacement explains on the owning Deployment why a pod was placed on
+// tier, given the counts observed before the placement. Bursts of similar events
+// are aggregated and rate limited by the correlator of the event broadcaster.
+func (a *MutatingAdmission) recordPlacement(namespace string, strategy *UserStrategy, tier string, onDemand, spot int, countsSource string) {
+	if a.Recorder == nil {
+		return
+	}
+	a.Recorder.Eventf(workloadRef(namespace, strategy), corev1.EventTypeNormal, EventReasonTierPlaced,
+		"Placed pod on %s: %d on-demand and %d spot pods observed (%s), low water level %d, high water level %d, strategy from %s",
+		tier, onDemand, spot, countsSource, strategy.LowWaterLevel, strategy.HighWaterLevel, strategy.Source)
+}
+
+// recordFallback explains on the owning Deployment why a pod was placed on the
+// fallback tier without being counted.
+func (a *MutatingAdmission) recordFallback(namespace string, strategy *UserStrategy, tier, reason string) {
+	if a.Recorder == nil {
+		return
+	}
+	source := "the webhook default"
+	if strategy.FallbackTier != "" {
+		source = fmt.Sprintf("annotation %s", AnnotationFallbackTier)
+	}
+	a.Recorder.Eventf(workloadRef(namespace, strategy), corev1.EventTypeWarning, EventReasonTierFallback,
+		"Placed pod on fallback tier %s from %s without counting: %s, low water level %d, high water level %d, strategy from %s",
+		tier, source, reason, strategy.LowWaterLevel, strategy.HighWaterLevel, strategy.Source)
+}
+
+func workloadRef(namespace string, strategy *UserStrategy) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  namespace,
+		Name:       strategy.Workload,
+		UID:        strategy.WorkloadUID,
+	}
+}
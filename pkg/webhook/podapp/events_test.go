@@ -0,0 +1,61 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	coorv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestMutatingAdmission_Handle_Events(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	client := fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0"))
+	recorder := record.NewFakeRecorder(10)
+	m := &MutatingAdmission{
+		Decoder:        &fakeMutationDecoder{obj: pod},
+		Client:         client,
+		DecisionBudget: 100 * time.Millisecond,
+		Recorder:       recorder,
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	m.Handle(context.Background(), req)
+	want := "Normal TierPlaced Placed pod on on-demand: 1 on-demand and 0 spot pods observed (listed), low water level 2, high water level 5, strategy from Deployment annotations"
+	if got := <-recorder.Events; got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+
+	// A lease held elsewhere makes the admission run out of budget.
+	if _, err := client.CoordinationV1().Leases(pod.Namespace).Create(context.Background(), &coorv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: leaseName(pod), Namespace: pod.Namespace},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create lease: %v", err)
+	}
+	m.Handle(context.Background(), req)
+	if got := <-recorder.Events; !strings.HasPrefix(got, "Warning TierFallback Placed pod on fallback tier spot from the webhook default without counting: deadline") {
+		t.Errorf("event = %q, want a fallback event", got)
+	}
+}
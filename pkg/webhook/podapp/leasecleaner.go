@@ -0,0 +1,92 @@
+package podapp
+
+import (
+	"context"
+	"time"
+
+	coorv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// LeaseCleaner periodically deletes workload Leases whose holder died while
+// deciding, which would otherwise block the workload until they are removed by
+// hand. It only runs on the elected leader.
+type LeaseCleaner struct {
+	Client kubernetes.Interface
+	// Interval is the time between two sweeps.
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+var _ manager.LeaderElectionRunnable = &LeaseCleaner{}
+
+// NeedLeaderElection implements the LeaderElectionRunnable interface, so that
+// only one replica sweeps.
+func (c *LeaseCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+// Start sweeps every Interval until ctx is done.
+func (c *LeaseCleaner) Start(ctx context.Context) error {
+	klog.Infof("Starting workload lease cleaner with interval %s", c.Interval)
+	wait.UntilWithContext(ctx, c.sweep, c.Interval)
+	return nil
+}
+
+func (c *LeaseCleaner) sweep(ctx context.Context) {
+	leases, err := c.Client.CoordinationV1().Leases(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{LabelWorkloadLock: "true"}).String(),
+	})
+	if err != nil {
+		klog.Errorf("Failed to list workload leases: %v", err)
+		return
+	}
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if !c.expired(lease) {
+			continue
+		}
+		// The precondition keeps a Lease that was re-acquired in the meantime.
+		err := c.Client.CoordinationV1().Leases(lease.Namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		switch {
+		case err == nil:
+			klog.Infof("Deleted expired workload lease %s/%s held by %s", lease.Namespace, lease.Name, holderOf(lease))
+			expiredLeasesDeleted.Inc()
+		case apierrors.IsNotFound(err), apierrors.IsConflict(err):
+		default:
+			klog.Errorf("Failed to delete expired workload lease %s/%s: %v", lease.Namespace, lease.Name, err)
+		}
+	}
+}
+
+func (c *LeaseCleaner) expired(lease *coorv1.Lease) bool {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	acquired := lease.CreationTimestamp.Time
+	if lease.Spec.AcquireTime != nil {
+		acquired = lease.Spec.AcquireTime.Time
+	}
+	duration := WorkloadLeaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return now().After(acquired.Add(duration))
+}
+
+func holderOf(lease *coorv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return "<unknown>"
+	}
+	return *lease.Spec.HolderIdentity
+}
@@ -0,0 +1,41 @@
+package podapp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coorv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestLeaseCleaner_Sweep(t *testing.T) {
+	now := time.Now()
+	newLease := func(name string, acquired time.Time, labels map[string]string) *coorv1.Lease {
+		return &coorv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec: coorv1.LeaseSpec{
+				AcquireTime:          &metav1.MicroTime{Time: acquired},
+				LeaseDurationSeconds: ptr.To(int32(60)),
+			},
+		}
+	}
+	workload := map[string]string{LabelWorkloadLock: "true"}
+	client := fake.NewSimpleClientset(
+		newLease("expired", now.Add(-2*time.Minute), workload),
+		newLease("fresh", now.Add(-10*time.Second), workload),
+		newLease("foreign", now.Add(-2*time.Minute), nil),
+	)
+
+	c := &LeaseCleaner{Client: client, now: func() time.Time { return now }}
+	c.sweep(context.Background())
+
+	for name, wantExists := range map[string]bool{"expired": false, "fresh": true, "foreign": true} {
+		_, err := client.CoordinationV1().Leases("default").Get(context.Background(), name, metav1.GetOptions{})
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("lease %s exists = %v, want %v", name, exists, wantExists)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package podapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+)
+
+// workloadQueues hands out per-workload turns in FIFO order. Admissions of the
+// same workload wait in line on a channel instead of polling the Lease, so only
+// the head of each queue talks to the API server.
+type workloadQueues struct {
+	mu     sync.Mutex
+	queues map[string]*workloadQueue
+}
+
+type workloadQueue struct {
+	// held reports whether some admission currently owns the turn.
+	held bool
+	// waiters are closed one at a time, oldest first, when the turn is handed over.
+	waiters []chan struct{}
+}
+
+func newWorkloadQueues() *workloadQueues {
+	return &workloadQueues{queues: map[string]*workloadQueue{}}
+}
+
+// Acquire blocks until the caller owns the turn for key or ctx is done.
+func (q *workloadQueues) Acquire(ctx context.Context, key string) error {
+	q.mu.Lock()
+	wq, ok := q.queues[key]
+	if !ok {
+		wq = &workloadQueue{}
+		q.queues[key] = wq
+	}
+	if !wq.held {
+		wq.held = true
+		q.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	wq.waiters = append(wq.waiters, ready)
+	lockWaiters.Inc()
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-ready:
+			// The turn was handed to us while we were giving up, pass it on.
+			q.releaseLocked(key, wq)
+		default:
+			wq.removeWaiter(ready)
+			lockWaiters.Dec()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release gives the turn for key to the oldest waiter, if any.
+func (q *workloadQueues) Release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if wq, ok := q.queues[key]; ok {
+		q.releaseLocked(key, wq)
+	}
+}
+
+// Depth returns the number of admissions waiting for the turn of key.
+func (q *workloadQueues) Depth(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if wq, ok := q.queues[key]; ok {
+		return len(wq.waiters)
+	}
+	return 0
+}
+
+func (q *workloadQueues) releaseLocked(key string, wq *workloadQueue) {
+	if len(wq.waiters) == 0 {
+		delete(q.queues, key)
+		return
+	}
+	next := wq.waiters[0]
+	wq.waiters = wq.waiters[1:]
+	lockWaiters.Dec()
+	close(next)
+}
+
+func (wq *workloadQueue) removeWaiter(ch chan struct{}) {
+	for i, w := range wq.waiters {
+		if w == ch {
+			wq.waiters = append(wq.waiters[:i], wq.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// heldLease is a workload Lease created by this replica and not yet deleted.
+type heldLease struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	// ReleaseFailed reports that the admission holding the Lease is done but
+	// failed to delete it. Shutdown retries the deletion until the Lease
+	// expires, after which it is forgotten.
+	ReleaseFailed bool `json:"releaseFailed,omitempty"`
+}
+
+// heldLeases tracks the workload Leases owned by this replica by lock key.
+type heldLeases struct {
+	mu     sync.Mutex
+	leases map[string]heldLease
+}
+
+func newHeldLeases() *heldLeases {
+	return &heldLeases{leases: map[string]heldLease{}}
+}
+
+func (h *heldLeases) add(key string, lease heldLease) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leases[key] = lease
+}
+
+func (h *heldLeases) remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.leases, key)
+}
+
+func (h *heldLeases) markReleaseFailed(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if lease, ok := h.leases[key]; ok {
+		lease.ReleaseFailed = true
+		h.leases[key] = lease
+	}
+}
+
+// pruneExpired forgets the leases that failed to release and expired before
+// now, which the other replicas and the lease cleaner take over, and returns
+// their keys.
+func (h *heldLeases) pruneExpired(now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var pruned []string
+	for key, lease := range h.leases {
+		if lease.ReleaseFailed && now.Sub(lease.AcquiredAt) > WorkloadLeaseDuration {
+			delete(h.leases, key)
+			pruned = append(pruned, key)
+		}
+	}
+	return pruned
+}
+
+// list returns a copy of the held leases by lock key.
+func (h *heldLeases) list() map[string]heldLease {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	leases := make(map[string]heldLease, len(h.leases))
+	for key, lease := range h.leases {
+		leases[key] = lease
+	}
+	return leases
+}
+
+// sortedKeys returns the keys of leases ordered by acquisition time, oldest first.
+func sortedKeys(leases map[string]heldLease) []string {
+	keys := make([]string, 0, len(leases))
+	for key := range leases {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return leases[keys[i]].AcquiredAt.Before(leases[keys[j]].AcquiredAt)
+	})
+	return keys
+}
+
+// lockIdentity returns the holder identity written to the workload Leases of
+// this replica, unique across restarts.
+func lockIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return hostname + "_" + uuid.New().String()
+}
+
+// LockCheck is a healthz.Checker that fails while an admission in flight holds
+// a workload Lease past its duration. Such a Lease is considered expired by the
+// other replicas and the lease cleaner, so the admission holding it is stuck.
+// Leases that only failed to release are forgotten once expired instead, as
+// the lease cleaner deletes them.
+func (a *MutatingAdmission) LockCheck(_ *http.Request) error {
+	a.initOnce.Do(a.init)
+	for _, key := range a.held.pruneExpired(time.Now()) {
+		klog.Warningf("Forgetting lease %s, which failed to release and has expired", key)
+	}
+	leases := a.held.list()
+	for _, key := range sortedKeys(leases) {
+		if held := time.Since(leases[key].AcquiredAt); held > WorkloadLeaseDuration {
+			return fmt.Errorf("lease %s held for %s, longer than its duration %s", key, held.Round(time.Second), WorkloadLeaseDuration)
+		}
+	}
+	return nil
+}
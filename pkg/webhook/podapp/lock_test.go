@@ -0,0 +1,167 @@
+package podapp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coorv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func TestWorkloadQueues_FIFO(t *testing.T) {
+	q := newWorkloadQueues()
+	key := "default/app-lease"
+	if err := q.Acquire(context.Background(), key); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		go func() {
+			if err := q.Acquire(context.Background(), key); err != nil {
+				t.Errorf("Acquire() error = %v", err)
+				return
+			}
+			order <- i
+			q.Release(key)
+		}()
+		// wait until the waiter is queued so that the enqueue order is deterministic.
+		waitForDepth(t, q, key, i+1)
+	}
+
+	q.Release(key)
+	for want := 0; want < 3; want++ {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("waiter %d woke up, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("waiter %d never woke up", want)
+		}
+	}
+	if depth := q.Depth(key); depth != 0 {
+		t.Errorf("Depth() = %d, want 0", depth)
+	}
+}
+
+func TestWorkloadQueues_CancelledWaiterLeavesQueue(t *testing.T) {
+	q := newWorkloadQueues()
+	key := "default/app-lease"
+	if err := q.Acquire(context.Background(), key); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- q.Acquire(ctx, key)
+	}()
+	waitForDepth(t, q, key, 1)
+	cancel()
+	if err := <-errCh; err == nil {
+		t.Fatalf("Acquire() error = nil, want context error")
+	}
+	if depth := q.Depth(key); depth != 0 {
+		t.Fatalf("Depth() = %d, want 0", depth)
+	}
+
+	// The turn must still be releasable and reusable.
+	q.Release(key)
+	acquireCtx, acquireCancel := context.WithTimeout(context.Background(), time.Second)
+	defer acquireCancel()
+	if err := q.Acquire(acquireCtx, key); err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+}
+
+func waitForDepth(t *testing.T, q *workloadQueues, key string, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Depth(key) != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("Depth() = %d, want %d", q.Depth(key), depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMutatingAdmission_LockCheck(t *testing.T) {
+	m := &MutatingAdmission{}
+	m.initOnce.Do(m.init)
+	m.held.add("test-namespace/app-5d4f8--lease", heldLease{Namespace: "test-namespace", Name: "app-5d4f8--lease", AcquiredAt: time.Now()})
+	if err := m.LockCheck(nil); err != nil {
+		t.Errorf("LockCheck() = %v for a fresh lease", err)
+	}
+	m.held.add("test-namespace/stuck-lease", heldLease{Namespace: "test-namespace", Name: "stuck-lease", AcquiredAt: time.Now().Add(-2 * WorkloadLeaseDuration)})
+	if err := m.LockCheck(nil); err == nil {
+		t.Errorf("LockCheck() = nil while a lease is held past its duration")
+	}
+}
+
+func TestMutatingAdmission_LockCheck_FailedRelease(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", GenerateName: "app-5d4f8-"}}
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("delete", "leases", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("overloaded")
+	})
+	m := &MutatingAdmission{Client: client}
+	m.initOnce.Do(m.init)
+	key := lockKey(pod)
+	acquiredAt := time.Now()
+	m.held.add(key, heldLease{Namespace: pod.Namespace, Name: leaseName(pod), AcquiredAt: acquiredAt})
+
+	if err := m.deleteLease(context.Background(), key, pod.Namespace, leaseName(pod)); err == nil {
+		t.Fatalf("deleteLease() = nil, want the delete error")
+	}
+	if err := m.LockCheck(nil); err != nil {
+		t.Errorf("LockCheck() = %v for a fresh lease", err)
+	}
+	if lease, ok := m.held.list()[key]; !ok || !lease.ReleaseFailed {
+		t.Fatalf("lease that failed to release = %+v, %v; want it tracked for shutdown", lease, ok)
+	}
+
+	// Once expired, the lease cleaner deletes it.
+	m.held.add(key, heldLease{Namespace: pod.Namespace, Name: leaseName(pod), AcquiredAt: acquiredAt.Add(-2 * WorkloadLeaseDuration), ReleaseFailed: true})
+	if err := m.LockCheck(nil); err != nil {
+		t.Errorf("LockCheck() = %v for a lease that only failed to release", err)
+	}
+	if _, ok := m.held.list()[key]; ok {
+		t.Errorf("expired lease that failed to release is still tracked")
+	}
+}
+
+func TestMutatingAdmission_TryAcquireLock_LogsRetriesOnce(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", GenerateName: "app-5d4f8-"}}
+	m := &MutatingAdmission{
+		Client: fake.NewSimpleClientset(&coorv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName(pod), Namespace: pod.Namespace},
+		}),
+	}
+	m.initOnce.Do(m.init)
+
+	logger := ktesting.NewLogger(t, ktesting.NewConfig(ktesting.Verbosity(4), ktesting.BufferLogs(true)))
+	ctx, cancel := context.WithTimeout(klog.NewContext(context.Background(), logger), 500*time.Millisecond)
+	defer cancel()
+	if err := m.tryAcquireLock(ctx, pod); err == nil {
+		t.Fatalf("tryAcquireLock() = nil while the lease is held elsewhere")
+	}
+
+	logs := logger.GetSink().(ktesting.Underlier).GetBuffer().String()
+	if n := strings.Count(logs, "Workload lease is held elsewhere"); n != 1 {
+		t.Errorf("logged the held lease %d times, want once:\n%s", n, logs)
+	}
+	if !strings.Contains(logs, "Gave up on workload lease") {
+		t.Errorf("logs = %s, want the attempts given up on", logs)
+	}
+}
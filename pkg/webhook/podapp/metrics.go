@@ -0,0 +1,113 @@
+package podapp
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	lockWaiters = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_lock_waiters",
+		Help: "Number of admissions queued behind another admission of the same workload.",
+	})
+	lockWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhook_lock_wait_duration_seconds",
+		Help:    "Time spent waiting for the workload lock, including the in-process queue and the Lease.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	inflightDecisions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_inflight_decisions",
+		Help: "Number of admissions currently holding a decision slot.",
+	})
+	fallbackDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_fallback_decisions_total",
+		Help: "Number of admissions answered without counting the workload, by reason.",
+	}, []string{"reason"})
+	cachedDecisions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_cached_decisions_total",
+		Help: "Number of admissions placed from cached counts while the API server circuit breaker was open.",
+	})
+	apiserverBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_apiserver_breaker_state",
+		Help: "State of the API server circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+	decisionCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_decision_cache_hits_total",
+		Help: "Number of retried admissions answered from the decision cache.",
+	})
+	decisionCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_decision_cache_entries",
+		Help: "Number of decisions currently held in the decision cache.",
+	})
+	decisionCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_decision_cache_evictions_total",
+		Help: "Number of decisions evicted from the decision cache because it was full.",
+	})
+	placementDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_placement_decisions_total",
+		Help: "Number of pods placed on a tier, by tier, namespace and workload.",
+	}, []string{"tier", "namespace", "workload"})
+	skippedAdmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_skipped_admissions_total",
+		Help: "Number of pods admitted unmutated, by reason.",
+	}, []string{"reason"})
+	ownerResolutionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhook_owner_resolution_duration_seconds",
+		Help:    "Time spent resolving the Deployment that owns a pod and reading its strategy.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	podListDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhook_pod_list_duration_seconds",
+		Help:    "Time spent listing the pods of a workload to count its tiers.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	workloadPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_workload_pods",
+		Help: "Number of pods of a ReplicaSet of a workload on each tier, as counted at its last placement decision.",
+	}, []string{"namespace", "workload", "replicaset", "tier"})
+	workloadLowWaterLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_workload_low_water_level",
+		Help: "Low water level of on-demand pods of a workload, as read at its last placement decision.",
+	}, []string{"namespace", "workload"})
+	workloadHighWaterLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webhook_workload_high_water_level",
+		Help: "High water level of a workload, as read at its last placement decision.",
+	}, []string{"namespace", "workload"})
+	expiredLeasesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webhook_expired_leases_deleted_total",
+		Help: "Number of workload leases deleted by the leader because their holder did not release them.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		lockWaiters,
+		lockWaitDuration,
+		inflightDecisions,
+		fallbackDecisions,
+		cachedDecisions,
+		apiserverBreakerState,
+		decisionCacheHits,
+		decisionCacheEntries,
+		decisionCacheEvictions,
+		expiredLeasesDeleted,
+		placementDecisions,
+		skippedAdmissions,
+		ownerResolutionDuration,
+		podListDuration,
+		workloadPods,
+		workloadLowWaterLevel,
+		workloadHighWaterLevel,
+	)
+}
+
+// recordWorkloadCounts exports the tier counts of a workload next to its water
+// levels, so that a drop of on-demand pods below the low water level can be
+// alerted on. Pods are counted per ReplicaSet, which the water levels apply
+// to, so that the old and new ReplicaSets of a rollout keep their own series.
+func recordWorkloadCounts(namespace, replicaSet string, strategy *UserStrategy, counts workloadCounts) {
+	workloadPods.WithLabelValues(namespace, strategy.Workload, replicaSet, OnDemandValue).Set(float64(counts.OnDemand))
+	workloadPods.WithLabelValues(namespace, strategy.Workload, replicaSet, SpotValue).Set(float64(counts.Spot))
+	workloadLowWaterLevel.WithLabelValues(namespace, strategy.Workload).Set(float64(strategy.LowWaterLevel))
+	workloadHighWaterLevel.WithLabelValues(namespace, strategy.Workload).Set(float64(strategy.HighWaterLevel))
+}
@@ -0,0 +1,149 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func metricValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Failed to read metric: %v", err)
+	}
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	}
+	return float64(m.Histogram.GetSampleCount())
+}
+
+func TestMutatingAdmission_Handle_Metrics(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	m := &MutatingAdmission{
+		Decoder: &fakeMutationDecoder{obj: pod},
+		Client:  fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0")),
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	decisions := placementDecisions.WithLabelValues(OnDemandValue, pod.Namespace, deploy.Name)
+	before := metricValue(t, decisions)
+	listed := metricValue(t, podListDuration)
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != OnDemandValue {
+		t.Fatalf("Handle() placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	if got := metricValue(t, decisions) - before; got != 1 {
+		t.Errorf("on-demand decisions increased by %v, want 1", got)
+	}
+	if got := metricValue(t, podListDuration) - listed; got != 1 {
+		t.Errorf("pod list observations increased by %v, want 1", got)
+	}
+	if got := metricValue(t, workloadPods.WithLabelValues(pod.Namespace, deploy.Name, rs.Name, OnDemandValue)); got != 2 {
+		t.Errorf("on-demand pods gauge = %v, want 2", got)
+	}
+	if got := metricValue(t, workloadLowWaterLevel.WithLabelValues(pod.Namespace, deploy.Name)); got != 2 {
+		t.Errorf("low water level gauge = %v, want 2", got)
+	}
+
+	skipped := skippedAdmissions.WithLabelValues(skipReasonOperation)
+	before = metricValue(t, skipped)
+	req.Operation = admissionv1.Update
+	if got := m.Handle(context.Background(), req); !got.Allowed || len(got.Patches) != 0 {
+		t.Fatalf("Handle() = %v, want an unmutated admission", got)
+	}
+	if got := metricValue(t, skipped) - before; got != 1 {
+		t.Errorf("skipped admissions increased by %v, want 1", got)
+	}
+
+	// A rollout counts the pods of the new ReplicaSet in their own series.
+	newRS := rs.DeepCopy()
+	newRS.Name, newRS.UID = "app-7f9c1", "rs-new-uid"
+	if _, err := m.Client.AppsV1().ReplicaSets(newRS.Namespace).Create(context.Background(), newRS, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create ReplicaSet: %v", err)
+	}
+	newPod := pod.DeepCopy()
+	newPod.GenerateName = newRS.Name + "-"
+	newPod.Labels["pod-template-hash"] = "7f9c1"
+	newPod.OwnerReferences[0].Name, newPod.OwnerReferences[0].UID = newRS.Name, newRS.UID
+	m.Decoder = &fakeMutationDecoder{obj: newPod}
+	req.Operation = admissionv1.Create
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != OnDemandValue {
+		t.Fatalf("Handle() placed the pod of the new ReplicaSet on %q, want %q", tier, OnDemandValue)
+	}
+	if got := metricValue(t, workloadPods.WithLabelValues(pod.Namespace, deploy.Name, newRS.Name, OnDemandValue)); got != 1 {
+		t.Errorf("on-demand pods gauge of the new ReplicaSet = %v, want 1", got)
+	}
+	if got := metricValue(t, workloadPods.WithLabelValues(pod.Namespace, deploy.Name, rs.Name, OnDemandValue)); got != 2 {
+		t.Errorf("on-demand pods gauge of the old ReplicaSet = %v, want 2", got)
+	}
+}
+
+func TestMutatingAdmission_Handle_OwnerDeadlineMetrics(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	client := fake.NewSimpleClientset(deploy, rs)
+	// The owner is resolved after the decision budget ran out.
+	client.PrependReactor("get", "replicasets", func(clienttesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(100 * time.Millisecond)
+		return true, nil, context.DeadlineExceeded
+	})
+	m := &MutatingAdmission{
+		Decoder:        &fakeMutationDecoder{obj: pod},
+		Client:         client,
+		DecisionBudget: 50 * time.Millisecond,
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	skipped := skippedAdmissions.WithLabelValues(skipReasonOwnerDeadline)
+	fallbacks := fallbackDecisions.WithLabelValues(skipReasonOwnerDeadline)
+	skippedBefore, fallbacksBefore := metricValue(t, skipped), metricValue(t, fallbacks)
+	if got := m.Handle(context.Background(), req); !got.Allowed || len(got.Patches) != 0 {
+		t.Fatalf("Handle() = %v, want an unmutated admission", got)
+	}
+	if got := metricValue(t, skipped) - skippedBefore; got != 1 {
+		t.Errorf("skipped admissions increased by %v, want 1", got)
+	}
+	if got := metricValue(t, fallbacks) - fallbacksBefore; got != 0 {
+		t.Errorf("fallback decisions increased by %v, want 0", got)
+	}
+}
@@ -3,79 +3,484 @@ package podapp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	coorv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
 )
 
 // MutatingAdmission mutates API request if necessary.
 type MutatingAdmission struct {
 	Decoder admission.Decoder
-	Client  *kubernetes.Clientset
+	Client  kubernetes.Interface
+	// MaxConcurrentDecisions bounds the number of admissions that may wait for
+	// a workload lock at the same time. Admissions beyond the limit are answered
+	// immediately with the fallback tier. Zero means unlimited.
+	MaxConcurrentDecisions int
+	// DecisionBudget bounds the time spent on a single admission. When it runs
+	// out the pod is placed on the fallback tier. Zero means no budget.
+	DecisionBudget time.Duration
+	// FallbackTier is the tier used for pods that cannot be counted in time when
+	// the strategy does not name one. Defaults to spot.
+	FallbackTier string
+	// Breaker guards the API server calls made while mutating pods. While it is
+	// open, pods are placed from the last known counts of their workload. Nil
+	// disables it.
+	Breaker *circuitbreaker.Breaker
+	// CountsStaleness bounds the age of the cached counts used while the breaker
+	// is open. Older counts lead to the fallback tier. Defaults to 1m.
+	CountsStaleness time.Duration
+	// DecisionCacheSize is the number of recent decisions remembered by request
+	// UID, so that a retried request gets the identical patch without being
+	// counted again. The decisions are not shared with the other replicas, to
+	// which the Service may send a retry. Zero disables the cache.
+	DecisionCacheSize int
+	// DecisionCacheTTL is how long a decision is remembered. Defaults to 2m.
+	DecisionCacheTTL time.Duration
+	// Recorder records an event on the owning Deployment for every placement.
+	// Nil disables the events.
+	Recorder record.EventRecorder
+
+	policy     atomic.Pointer[config.Policy]
+	relabelled atomic.Pointer[config.Tier]
+	initOnce   sync.Once
+	identity   string
+	held       *heldLeases
+	inflight   atomic.Int64
+	draining   atomic.Bool
+	decisions  *decisionCache
+	queues     *workloadQueues
+	slots      chan struct{}
+	counts     *countsCache
+	recent     *decisionLog
+	priorities *priorityClasses
+	zones      *nodeLabels
+	types      *nodeLabels
 }
 
 const (
 	AnnotationLowWaterLevel        string = "webhook-demo.com/low-water-level"
 	AnnotationHighWaterLevel       string = "webhook-demo.com/high-water-level"
 	AnnotationScheduleCompensation string = "webhook-demo.com/schedule-compensation"
+	AnnotationFallbackTier         string = "webhook-demo.com/fallback-tier"
 	OnDemandNodeLabelKey           string = "node.kubernetes.io/capacity"
-	OnDemandValue                  string = "on-demand"
+	OnDemandValue                  string = config.TierOnDemand
 	SpotNodeLabelKey               string = "node.kubernetes.io/capacity"
-	SpotValue                      string = "spot"
+	SpotValue                      string = config.TierSpot
 	PDC                            string = "controller.kubernetes.io/pod-deletion-cost"
+	LabelWorkloadLock              string = "webhook-demo.com/workload-lock"
+)
+
+// defaultFallbackTier is the tier given to pods that cannot be counted in time
+// when neither the strategy nor the handler names one. The spot preference is
+// soft, so it never leaves a pod unschedulable, and the next counted admission
+// restores the on-demand floor.
+const defaultFallbackTier = SpotValue
+
+// Reasons recorded when a pod is placed on the fallback tier without counting
+// its workload.
+const (
+	// fallbackReasonSaturated means all decision slots were busy.
+	fallbackReasonSaturated = "saturated"
+	// fallbackReasonDeadline means the decision budget ran out while waiting for
+	// the workload lock or listing its pods.
+	fallbackReasonDeadline = "deadline"
+	// fallbackReasonBreakerOpen means the API server circuit breaker is open and
+	// there are no fresh cached counts of the workload.
+	fallbackReasonBreakerOpen = "breaker-open"
+)
+
+// Reasons recorded when a pod is admitted unmutated.
+const (
+	// skipReasonOperation means the request is not a pod creation.
+	skipReasonOperation = "operation"
+	// skipReasonUnmanaged means the pod is not owned by a Deployment.
+	skipReasonUnmanaged = "unmanaged"
+	// skipReasonDisabled means the Deployment did not enable schedule compensation
+	// or set both water levels.
+	skipReasonDisabled = "disabled"
+	// skipReasonOwnerDeadline means the decision budget ran out before the
+	// strategy was known.
+	skipReasonOwnerDeadline = "owner-deadline"
+	// skipReasonBreakerOpen means the API server circuit breaker is open and the
+	// workload was never counted.
+	skipReasonBreakerOpen = "breaker-open"
 )
 
+const (
+	// defaultCountsStaleness is the default of MutatingAdmission.CountsStaleness.
+	defaultCountsStaleness = time.Minute
+	// defaultDecisionCacheTTL is the default of MutatingAdmission.DecisionCacheTTL.
+	defaultDecisionCacheTTL = 2 * time.Minute
+)
+
+// WorkloadLeaseDuration is written to every workload Lease. A Lease older than
+// that was left behind by a replica that died while deciding, and is removed
+// by the LeaseCleaner. The decision budget is validated to be shorter.
+const WorkloadLeaseDuration = time.Minute
+
+// lockReleaseTimeout bounds the Lease deletion done after a decision, which
+// must succeed even when the decision budget is already spent.
+const lockReleaseTimeout = 5 * time.Second
+
 // Check if our MutatingAdmission implements necessary interface
 var _ admission.Handler = &MutatingAdmission{}
 
 // Handle yields a response to an AdmissionRequest.
 func (a *MutatingAdmission) Handle(ctx context.Context, req admission.Request) admission.Response {
+	a.initOnce.Do(a.init)
+	a.inflight.Add(1)
+	defer a.inflight.Add(-1)
+	logger := klog.FromContext(ctx).WithValues("uid", req.UID, "namespace", req.Namespace, "operation", req.Operation)
+	ctx = klog.NewContext(ctx, logger)
+	if resp, ok := a.cachedDecision(ctx, req); ok {
+		return resp
+	}
+	if a.DecisionBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.DecisionBudget)
+		defer cancel()
+	}
 	pod := &corev1.Pod{}
 
 	err := a.Decoder.Decode(req, pod)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
-	klog.V(2).Infof("Mutating Pod(%s/%s) for request: %s", req.Namespace, pod.Name, req.Operation)
+	logger = logger.WithValues("generateName", pod.GenerateName)
+	ctx = klog.NewContext(ctx, logger)
+	logger.V(2).Info("Mutating pod")
 
+	// The node affinity of a pod is immutable, so only new pods are placed.
+	if req.Operation != admissionv1.Create {
+		return a.skip(ctx, req, pod, skipReasonOperation)
+	}
+
+	start := time.Now()
 	strategy, err := a.GetAnnotationsOfDeployment(ctx, pod)
+	ownerResolutionDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			return a.cachedResponse(ctx, req, pod)
+		}
+		if ctx.Err() != nil {
+			logger.Info("Decision budget exceeded while resolving the strategy", "err", err)
+			return a.skip(ctx, req, pod, skipReasonOwnerDeadline)
+		}
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
+	if strategy == nil {
+		return a.skip(ctx, req, pod, skipReasonUnmanaged)
+	}
+	logger = logger.WithValues("workload", strategy.Workload)
+	ctx = klog.NewContext(ctx, logger)
 	if !a.shouldMutate(strategy) {
-		klog.V(2).Infof("Skip mutating Pod(%s/%s) for request: %s", req.Namespace, pod.Name, req.Operation)
-		return admission.Allowed("")
+		return a.skip(ctx, req, pod, skipReasonDisabled)
 	}
 
-	if !a.tryAcquireLock(ctx, pod) {
+	if !a.tryAcquireSlot() {
+		return a.fallbackResponse(ctx, req, pod, strategy, fallbackReasonSaturated)
+	}
+	defer a.releaseSlot()
+
+	if err := a.tryAcquireLock(ctx, pod); err != nil {
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			return a.cachedResponse(ctx, req, pod)
+		}
+		if ctx.Err() != nil {
+			return a.fallbackResponse(ctx, req, pod, strategy, fallbackReasonDeadline)
+		}
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 	defer a.releaseLock(ctx, pod)
 
+	// A retry of a request that was still deciding when it was sent queues
+	// behind the original, which has cached its decision by now.
+	if resp, ok := a.cachedDecision(ctx, req); ok {
+		return resp
+	}
+
 	// list all pod have same label
-	podList, err := a.Client.CoreV1().Pods(req.Namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labels.SelectorFromSet(pod.Labels).String(),
+	var podList *corev1.PodList
+	start = time.Now()
+	err = a.call(ctx, func() (err error) {
+		podList, err = a.Client.CoreV1().Pods(req.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(pod.Labels).String(),
+		})
+		return err
 	})
+	podListDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			return a.cachedResponse(ctx, req, pod)
+		}
+		if ctx.Err() != nil {
+			return a.fallbackResponse(ctx, req, pod, strategy, fallbackReasonDeadline)
+		}
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
-	if a.countOnDemandPod(podList) < strategy.LowWaterLevel {
-		a.ensureOnDemandNodeAffinityOfPod(pod)
-		a.ensurePodDeleteCost(OnDemandValue, pod)
+	onDemand := a.countOnDemandPod(podList)
+	a.counts.observe(lockKey(pod), strategy, onDemand, len(podList.Items)-onDemand, podList)
+
+	placement := newPlacement(strategy, onDemand, len(podList.Items)-onDemand)
+	a.refinePlacement(ctx, &placement, strategy, pod, podList, a.listedTierNodeLabels(ctx))
+	counts, _ := a.counts.record(lockKey(pod), placement.Tier, a.placedPod(placement))
+	recordWorkloadCounts(req.Namespace, replicaSetName(pod), strategy, counts)
+	a.recordPlacement(req.Namespace, strategy, placement.Tier, onDemand, len(podList.Items)-onDemand, countsSourceListed)
+	return a.patchResponse(ctx, req, pod, strategy, placement)
+}
+
+// refinePlacement applies the zone-aware floor and the spot diversification of
+// strategy to the placement of pod, given the pods of its workload in podList
+// and the node labels returned by labels.
+func (a *MutatingAdmission) refinePlacement(ctx context.Context, placement *Placement, strategy *UserStrategy, pod *corev1.Pod, podList *corev1.PodList, labels tierLabelsFunc) {
+	if strategy.ZoneAwareFloor {
+		if zone, ok := a.zoneBelowFloor(ctx, strategy, podList, labels); ok {
+			placement.Tier, placement.Rule, placement.Zone = OnDemandValue, RuleBelowZoneFloor, zone
+		}
+	}
+	if placement.Tier == SpotValue && strategy.SpotDiversification && !hasInstanceTypeAffinity(pod) {
+		placement.InstanceTypes, placement.CappedInstanceTypes = a.diversifyInstanceTypes(ctx, strategy, podList, labels)
+	}
+}
+
+// placedPod returns a pod with the node affinity of placement, by which the
+// zone-aware floor and the spot diversification count the placed pod until it
+// is listed.
+func (a *MutatingAdmission) placedPod(placement Placement) *corev1.Pod {
+	pod := &corev1.Pod{}
+	if placement.Tier == OnDemandValue {
+		a.ensureOnDemandNodeAffinityInZone(pod, placement.Zone)
 	} else {
+		a.ensureInstanceTypeAffinity(pod, placement.InstanceTypes, placement.CappedInstanceTypes)
+	}
+	return pod
+}
+
+// skip admits pod unmutated and records why.
+func (a *MutatingAdmission) skip(ctx context.Context, req admission.Request, pod *corev1.Pod, reason string) admission.Response {
+	klog.FromContext(ctx).V(2).Info("Skip mutating pod", "reason", reason)
+	skippedAdmissions.WithLabelValues(reason).Inc()
+	resp := admission.Allowed("")
+	resp.AuditAnnotations = map[string]string{audit.AnnotationReason: "skipped: " + reason}
+	a.recordDecision(req.UID, req.Namespace, pod, resp.AuditAnnotations[audit.AnnotationReason])
+	return resp
+}
+
+func (a *MutatingAdmission) init() {
+	a.identity = lockIdentity()
+	a.held = newHeldLeases()
+	a.queues = newWorkloadQueues()
+	a.counts = newCountsCache()
+	a.recent = newDecisionLog(recentDecisionsSize)
+	a.priorities = newPriorityClasses()
+	a.zones = &nodeLabels{label: corev1.LabelTopologyZone}
+	a.types = &nodeLabels{label: corev1.LabelInstanceTypeStable}
+	if a.MaxConcurrentDecisions > 0 {
+		a.slots = make(chan struct{}, a.MaxConcurrentDecisions)
+	}
+	if a.DecisionCacheSize > 0 {
+		ttl := a.DecisionCacheTTL
+		if ttl <= 0 {
+			ttl = defaultDecisionCacheTTL
+		}
+		a.decisions = newDecisionCache(a.DecisionCacheSize, ttl)
+	}
+}
+
+// cachedDecision returns the response already given to a request with the same UID.
+func (a *MutatingAdmission) cachedDecision(ctx context.Context, req admission.Request) (admission.Response, bool) {
+	resp, ok := a.decisions.get(req.UID)
+	if ok {
+		klog.FromContext(ctx).V(2).Info("Replaying the decision of a retried request")
+		decisionCacheHits.Inc()
+	}
+	return resp, ok
+}
+
+// tryAcquireSlot takes a decision slot without blocking. It reports false when
+// all slots are busy.
+func (a *MutatingAdmission) tryAcquireSlot() bool {
+	if a.slots == nil {
+		return true
+	}
+	select {
+	case a.slots <- struct{}{}:
+		inflightDecisions.Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *MutatingAdmission) releaseSlot() {
+	if a.slots == nil {
+		return
+	}
+	<-a.slots
+	inflightDecisions.Dec()
+}
+
+// call runs fn, a request to the API server made within ctx, through the
+// breaker. Once ctx is done, such as when the decision budget ran out, fn is not
+// run, and the error of a run that outlived ctx is not held against the API
+// server.
+func (a *MutatingAdmission) call(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.Breaker == nil {
+		return fn()
+	}
+	err := a.Breaker.Do(func() error {
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			return &callerDoneError{err: err}
+		}
+		return err
+	})
+	if done := (*callerDoneError)(nil); errors.As(err, &done) {
+		return done.err
+	}
+	return err
+}
+
+// BreakerCheck is a healthz.Checker that fails while the API server circuit
+// breaker is open. Being open on every replica at once during an outage, it is
+// meant to be served by name rather than to fail the aggregate readiness.
+func (a *MutatingAdmission) BreakerCheck(_ *http.Request) error {
+	if a.Breaker != nil && a.Breaker.State() == circuitbreaker.Open {
+		return errors.New("API server circuit breaker is open")
+	}
+	return nil
+}
+
+// cachedResponse places pod from the last known counts of its workload while the
+// API server circuit breaker is open. Pods of workloads that were never counted
+// are admitted unmutated, as it is unknown whether they opted in.
+func (a *MutatingAdmission) cachedResponse(ctx context.Context, req admission.Request, pod *corev1.Pod) admission.Response {
+	logger := klog.FromContext(ctx)
+	key := lockKey(pod)
+	staleness := a.CountsStaleness
+	if staleness <= 0 {
+		staleness = defaultCountsStaleness
+	}
+
+	counts, known, _ := a.counts.get(key, staleness)
+	if !known {
+		logger.Info("API server circuit breaker is open and the strategy of the pod is unknown")
+		return a.skip(ctx, req, pod, skipReasonBreakerOpen)
+	}
+	// The zone-aware floor and the spot diversification apply as well, from the
+	// cached pods and the node labels last listed.
+	counts, placement, fresh := a.counts.decide(key, staleness, func(counts workloadCounts) (Placement, *corev1.Pod) {
+		placement := newPlacement(&counts.Strategy, counts.OnDemand, counts.Spot)
+		a.refinePlacement(ctx, &placement, &counts.Strategy, pod, &corev1.PodList{Items: counts.Pods}, a.cachedTierNodeLabels)
+		return placement, a.placedPod(placement)
+	})
+	if !fresh {
+		return a.fallbackResponse(ctx, req, pod, &counts.Strategy, fallbackReasonBreakerOpen)
+	}
+	logger.V(2).Info("API server circuit breaker is open, placing pod from cached counts", "tier", placement.Tier, "observedAt", counts.ObservedAt)
+	cachedDecisions.Inc()
+	recordWorkloadCounts(req.Namespace, replicaSetName(pod), &counts.Strategy, counts)
+	a.recordPlacement(req.Namespace, &counts.Strategy, placement.Tier, *placement.OnDemand, *placement.Spot,
+		fmt.Sprintf("cached at %s while the API server circuit breaker is open", counts.ObservedAt.Format(time.RFC3339)))
+	placement.Cached = true
+	return a.patchResponse(ctx, req, pod, &counts.Strategy, placement)
+}
+
+// fallbackResponse places pod on the fallback tier of strategy without counting
+// its workload and records why.
+func (a *MutatingAdmission) fallbackResponse(ctx context.Context, req admission.Request, pod *corev1.Pod, strategy *UserStrategy, reason string) admission.Response {
+	tier := a.fallbackTier(strategy)
+	klog.FromContext(ctx).Info("Placing pod on fallback tier", "tier", tier, "reason", reason)
+	fallbackDecisions.WithLabelValues(reason).Inc()
+	a.recordFallback(req.Namespace, strategy, tier, reason)
+	return a.patchResponse(ctx, req, pod, strategy, newFallbackPlacement(strategy, tier, reason))
+}
+
+// SetPolicy replaces the placement policy, which takes precedence over
+// FallbackTier and the default tier labels. It is safe to call while serving.
+// When it changes the node labels of the on-demand tier, the pods placed under
+// the previous ones still count as on-demand, so that the workloads are not
+// recounted as spot while their pods are replaced.
+func (a *MutatingAdmission) SetPolicy(p config.Policy) {
+	if previous := a.policy.Load(); previous != nil {
+		old, next := previous.Tiers[OnDemandValue], p.Tiers[OnDemandValue]
+		if old.NodeLabelKey != next.NodeLabelKey || old.NodeLabelValue != next.NodeLabelValue {
+			klog.Infof("On-demand tier relabelled from %s=%s to %s=%s, the pods placed under either count as on-demand",
+				old.NodeLabelKey, old.NodeLabelValue, next.NodeLabelKey, next.NodeLabelValue)
+			a.relabelled.Store(&old)
+		}
+	}
+	a.policy.Store(&p)
+}
+
+// currentPolicy returns the policy set by SetPolicy, or the default policy with
+// FallbackTier.
+func (a *MutatingAdmission) currentPolicy() *config.Policy {
+	if p := a.policy.Load(); p != nil {
+		return p
+	}
+	p := config.DefaultPolicy()
+	p.FallbackTier = a.FallbackTier
+	return &p
+}
+
+func (a *MutatingAdmission) fallbackTier(s *UserStrategy) string {
+	if s.FallbackTier != "" {
+		return s.FallbackTier
+	}
+	if p := a.currentPolicy(); p.FallbackTier != "" {
+		return p.FallbackTier
+	}
+	return defaultFallbackTier
+}
+
+// patchResponse places pod on the tier of placement, records its provenance and
+// returns the patch against the original object.
+func (a *MutatingAdmission) patchResponse(ctx context.Context, req admission.Request, pod *corev1.Pod, strategy *UserStrategy, placement Placement) admission.Response {
+	tier := placement.Tier
+	placementDecisions.WithLabelValues(tier, req.Namespace, strategy.Workload).Inc()
+	switch tier {
+	case OnDemandValue:
+		a.ensureOnDemandNodeAffinityInZone(pod, placement.Zone)
+	default:
 		a.ensureSpotNodeAffinityOfPod(pod)
-		a.ensurePodDeleteCost(SpotValue, pod)
+		a.ensureInstanceTypeAffinity(pod, placement.InstanceTypes, placement.CappedInstanceTypes)
+	}
+	a.ensurePodDeleteCost(tier, pod)
+	a.ensureNoExecuteTolerations(tier, pod)
+	a.ensureTaintTolerations(tier, pod)
+	a.ensurePriorityAndScheduler(ctx, tier, pod)
+	a.ensureSpread(tier, strategy, pod)
+	if err := setPlacementAnnotation(pod, placement); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
 	marshaledBytes, err := json.Marshal(pod)
@@ -83,28 +488,47 @@ func (a *MutatingAdmission) Handle(ctx context.Context, req admission.Request) a
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledBytes)
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, marshaledBytes)
+	resp.AuditAnnotations = map[string]string{audit.AnnotationReason: placement.String()}
+	a.recordDecision(req.UID, req.Namespace, pod, placement.String())
+	if resp.Allowed {
+		a.decisions.add(req.UID, resp)
+	}
+	return resp
 }
 
 func (a *MutatingAdmission) shouldMutate(s *UserStrategy) bool {
-	return s.ScheduleCompensation != nil && *s.ScheduleCompensation &&
+	return s != nil && s.ScheduleCompensation != nil && *s.ScheduleCompensation &&
 		s.LowWaterLevel > 0 && s.HighWaterLevel > 0
 }
 
 func (a *MutatingAdmission) ensureOnDemandNodeAffinityOfPod(pod *corev1.Pod) {
+	a.ensureOnDemandNodeAffinityInZone(pod, "")
+}
+
+// ensureOnDemandNodeAffinityInZone requires the on-demand nodes of zone, or of
+// any zone if it is empty.
+func (a *MutatingAdmission) ensureOnDemandNodeAffinityInZone(pod *corev1.Pod, zone string) {
+	tier := a.currentPolicy().Tiers[OnDemandValue]
+	requirements := []corev1.NodeSelectorRequirement{
+		{
+			Key:      tier.NodeLabelKey,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{tier.NodeLabelValue},
+		},
+	}
+	if zone != "" {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      corev1.LabelTopologyZone,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{zone},
+		})
+	}
 	OnDemandNodeAffinity := &corev1.Affinity{
 		NodeAffinity: &corev1.NodeAffinity{
 			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
 				NodeSelectorTerms: []corev1.NodeSelectorTerm{
-					{
-						MatchExpressions: []corev1.NodeSelectorRequirement{
-							{
-								Key:      OnDemandNodeLabelKey,
-								Operator: corev1.NodeSelectorOpIn,
-								Values:   []string{OnDemandValue},
-							},
-						},
-					},
+					{MatchExpressions: requirements},
 				},
 			},
 		},
@@ -117,15 +541,13 @@ func (a *MutatingAdmission) ensurePodDeleteCost(t string, pod *corev1.Pod) {
 		pod.Annotations = make(map[string]string)
 	}
 	if _, ok := pod.Annotations[PDC]; !ok {
-		switch t {
-		case OnDemandValue:
-			pod.Annotations[PDC] = "20000"
-		case SpotValue:
-			pod.Annotations[PDC] = "100"
+		if tier, ok := a.currentPolicy().Tiers[t]; ok && tier.DeletionCost != nil {
+			pod.Annotations[PDC] = strconv.Itoa(int(*tier.DeletionCost))
 		}
 	}
 }
 func (a *MutatingAdmission) ensureSpotNodeAffinityOfPod(pod *corev1.Pod) {
+	tier := a.currentPolicy().Tiers[SpotValue]
 	SpotNodeAffinity := &corev1.Affinity{
 		NodeAffinity: &corev1.NodeAffinity{
 			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
@@ -134,9 +556,9 @@ func (a *MutatingAdmission) ensureSpotNodeAffinityOfPod(pod *corev1.Pod) {
 					Preference: corev1.NodeSelectorTerm{
 						MatchExpressions: []corev1.NodeSelectorRequirement{
 							{
-								Key:      SpotNodeLabelKey,
+								Key:      tier.NodeLabelKey,
 								Operator: corev1.NodeSelectorOpIn,
-								Values:   []string{SpotValue},
+								Values:   []string{tier.NodeLabelValue},
 							},
 						},
 					},
@@ -149,22 +571,34 @@ func (a *MutatingAdmission) ensureSpotNodeAffinityOfPod(pod *corev1.Pod) {
 
 func (a *MutatingAdmission) countOnDemandPod(podList *corev1.PodList) int {
 	count := 0
-	for _, pod := range podList.Items {
-		if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms == nil {
-			continue
+	for i := range podList.Items {
+		if a.isOnDemandPod(&podList.Items[i]) {
+			count++
 		}
-		for _, k := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
-			if k.MatchExpressions == nil {
-				continue
-			}
-			for _, v := range k.MatchExpressions {
-				if v.Key == OnDemandNodeLabelKey && v.Operator == corev1.NodeSelectorOpIn && v.Values[0] == OnDemandValue {
-					count++
+	}
+	return count
+}
+
+// isOnDemandPod tells whether pod requires the on-demand nodes, under the node
+// labels of the policy or those it was relabelled from.
+func (a *MutatingAdmission) isOnDemandPod(pod *corev1.Pod) bool {
+	tiers := []config.Tier{a.currentPolicy().Tiers[OnDemandValue]}
+	if relabelled := a.relabelled.Load(); relabelled != nil {
+		tiers = append(tiers, *relabelled)
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, k := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, v := range k.MatchExpressions {
+			for _, tier := range tiers {
+				if v.Key == tier.NodeLabelKey && v.Operator == corev1.NodeSelectorOpIn && len(v.Values) > 0 && v.Values[0] == tier.NodeLabelValue {
+					return true
 				}
 			}
 		}
 	}
-	return count
+	return false
 }
 
 func (a *MutatingAdmission) mergeNodeAffinity(t string, pod *corev1.Pod, affinity *corev1.Affinity) {
@@ -194,83 +628,202 @@ func (a *MutatingAdmission) mergeNodeAffinity(t string, pod *corev1.Pod, affinit
 	}
 }
 
-func (a *MutatingAdmission) tryAcquireLock(ctx context.Context, pod *corev1.Pod) bool {
+// tryAcquireLock waits for the turn of the pod's workload in this replica and
+// then takes the workload Lease, which serializes replicas. Only the head of
+// the in-process queue retries the Lease, with backoff, while it is held elsewhere.
+func (a *MutatingAdmission) tryAcquireLock(ctx context.Context, pod *corev1.Pod) error {
+	logger := klog.FromContext(ctx)
+	start := time.Now()
+	defer func() {
+		lockWaitDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	key := lockKey(pod)
+	if err := a.queues.Acquire(ctx, key); err != nil {
+		return err
+	}
+
 	lease := &coorv1.Lease{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pod.GenerateName + "-" + "lease",
+			Name:      leaseName(pod),
 			Namespace: pod.Namespace,
+			Labels:    map[string]string{LabelWorkloadLock: "true"},
 		},
 		Spec: coorv1.LeaseSpec{
-			HolderIdentity: &pod.Name,
+			HolderIdentity:       &a.identity,
+			LeaseDurationSeconds: ptr.To(int32(WorkloadLeaseDuration / time.Second)),
 		},
 	}
-	ticker := time.NewTicker(time.Millisecond * 100)
-
+	backoff := wait.Backoff{
+		Duration: 50 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      2 * time.Second,
+	}
+	// Retries are logged once per distinct error rather than once per attempt.
+	attempts, lastErr := 0, ""
 	for {
-		select {
-		case <-ctx.Done():
-			return false
-		case <-ticker.C:
+		attempts++
+		lease.Spec.AcquireTime = &metav1.MicroTime{Time: time.Now()}
+		err := a.call(ctx, func() error {
 			_, err := a.Client.CoordinationV1().Leases(pod.Namespace).Create(ctx, lease, metav1.CreateOptions{})
-			if err == nil {
-				return true
+			return err
+		})
+		if err == nil {
+			a.held.add(key, heldLease{Namespace: pod.Namespace, Name: lease.Name, AcquiredAt: time.Now()})
+			if attempts > 1 {
+				logger.V(4).Info("Acquired workload lease", "lease", key, "attempts", attempts, "wait", time.Since(start))
+			}
+			return nil
+		}
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			a.queues.Release(key)
+			return err
+		}
+		if ctx.Err() == nil && err.Error() != lastErr {
+			lastErr = err.Error()
+			if apierrors.IsAlreadyExists(err) {
+				logger.V(4).Info("Workload lease is held elsewhere, waiting", "lease", key)
+			} else {
+				logger.Error(err, "Failed to create workload lease, retrying", "lease", key, "attempt", attempts)
 			}
-			klog.Error(err)
 		}
 
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.queues.Release(key)
+			logger.V(2).Info("Gave up on workload lease", "lease", key, "attempts", attempts, "wait", time.Since(start))
+			return ctx.Err()
+		case <-timer.C:
+		}
 	}
 }
 
+// releaseLock deletes the workload Lease and hands the turn to the next waiter.
+// The deletion does not inherit the deadline of ctx, so that a spent decision
+// budget does not leak the Lease, and it bypasses the breaker for the same reason.
 func (a *MutatingAdmission) releaseLock(ctx context.Context, pod *corev1.Pod) error {
-	leaseName := pod.GenerateName + "-" + "lease"
-	return a.Client.CoordinationV1().Leases(pod.Namespace).Delete(ctx, leaseName, metav1.DeleteOptions{})
+	key := lockKey(pod)
+	defer a.queues.Release(key)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+	defer cancel()
+	return a.deleteLease(ctx, key, pod.Namespace, leaseName(pod))
+}
+
+// deleteLease deletes a workload Lease and forgets it once it is gone. Leases
+// that fail to delete stay tracked as failed releases until they expire, so
+// that shutdown retries and reports them.
+func (a *MutatingAdmission) deleteLease(ctx context.Context, key, namespace, name string) error {
+	err := a.Client.CoordinationV1().Leases(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.FromContext(ctx).Error(err, "Failed to release workload lease", "lease", key)
+		a.held.markReleaseFailed(key)
+		return err
+	}
+	a.held.remove(key)
+	return nil
+}
+
+func leaseName(pod *corev1.Pod) string {
+	return pod.GenerateName + "-" + "lease"
+}
+
+func lockKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + leaseName(pod)
+}
+
+// replicaSetName returns the name of the ReplicaSet that owns pod, whose pods
+// are counted together.
+func replicaSetName(pod *corev1.Pod) string {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return owner.Name
+	}
+	return ""
 }
 
 type UserStrategy struct {
+	// Workload is the name of the Deployment the strategy was read from.
+	Workload string
+	// WorkloadUID is the UID of that Deployment, events are recorded against it.
+	WorkloadUID types.UID
+	// Source describes where the strategy was read from.
+	Source string
+	// Generation is the generation of the Deployment the strategy was read from.
+	Generation           int64
 	LowWaterLevel        int
 	HighWaterLevel       int
 	ScheduleCompensation *bool
+	// FallbackTier is the tier used when the pod cannot be counted in time.
+	// Empty means the handler default.
+	FallbackTier string
+	// Selector is the selector of the Deployment, which scopes the spreading
+	// of its pods.
+	Selector *metav1.LabelSelector
+	// Spread are the spread constraints of the pods of each tier.
+	Spread map[string][]SpreadConstraint
+	// ZoneAwareFloor enforces the low water level per zone.
+	ZoneAwareFloor bool
+	// SpotDiversification spreads the spot pods over the instance types of the
+	// spot nodes.
+	SpotDiversification bool
+	// SpotMaxInstanceTypeShare caps the share of the spot pods on an instance
+	// type, in percent. 0 means no cap.
+	SpotMaxInstanceTypeShare int
 }
 
+// GetAnnotationsOfDeployment resolves the strategy from the Deployment that owns pod
+// through its ReplicaSet. It returns a nil strategy for pods that are not managed
+// by a Deployment.
 func (a *MutatingAdmission) GetAnnotationsOfDeployment(ctx context.Context, pod *corev1.Pod) (*UserStrategy, error) {
-	var replisetName string
-	if pod.OwnerReferences == nil {
-		return nil, fmt.Errorf("pod.OwnerReferences is nil")
-	}
-	for _, v := range pod.OwnerReferences {
-		if *v.Controller {
-			replisetName = v.Name
-			break
-		}
-	}
-	if replisetName == "" {
-		return nil, fmt.Errorf("replisetName is empty")
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return nil, nil
 	}
 
-	repliset, err := a.Client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, replisetName, metav1.GetOptions{})
+	var repliset *appsv1.ReplicaSet
+	err := a.call(ctx, func() (err error) {
+		repliset, err = a.Client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	var deployName string
-	for _, v := range repliset.OwnerReferences {
-		if *v.Controller {
-			deployName = v.Name
-			break
-		}
+	owner = metav1.GetControllerOf(repliset)
+	if owner == nil || owner.Kind != "Deployment" {
+		return nil, nil
 	}
-	if deployName == "" {
-		return nil, fmt.Errorf("deployName is empty")
-	}
-	deploy, err := a.Client.AppsV1().Deployments(pod.Namespace).Get(ctx, deployName, metav1.GetOptions{})
+	var deploy *appsv1.Deployment
+	err = a.call(ctx, func() (err error) {
+		deploy, err = a.Client.AppsV1().Deployments(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	klog.FromContext(ctx).V(4).Info("Resolved strategy", "deployment", klog.KObj(deploy), "generation", deploy.Generation)
 	return &UserStrategy{
+		Workload:             deploy.Name,
+		WorkloadUID:          deploy.UID,
+		Source:               strategySourceAnnotations,
+		Generation:           deploy.Generation,
 		LowWaterLevel:        GetWaterLevel(deploy.GetAnnotations(), AnnotationLowWaterLevel),
 		HighWaterLevel:       GetWaterLevel(deploy.GetAnnotations(), AnnotationHighWaterLevel),
-		ScheduleCompensation: ScheduleCompensation(deploy.GetAnnotations(), AnnotationScheduleCompensation),
+		ScheduleCompensation: GetBoolAnnotation(deploy.GetAnnotations(), AnnotationScheduleCompensation),
+		FallbackTier:         GetFallbackTier(deploy.GetAnnotations(), AnnotationFallbackTier),
+		Selector:             deploy.Spec.Selector,
+		Spread: map[string][]SpreadConstraint{
+			OnDemandValue: GetSpread(deploy.GetAnnotations(), AnnotationOnDemandSpread),
+			SpotValue:     GetSpread(deploy.GetAnnotations(), AnnotationSpotSpread),
+		},
+		ZoneAwareFloor:           ptr.Deref(GetBoolAnnotation(deploy.GetAnnotations(), AnnotationZoneAwareFloor), false),
+		SpotDiversification:      ptr.Deref(GetBoolAnnotation(deploy.GetAnnotations(), AnnotationSpotDiversification), false),
+		SpotMaxInstanceTypeShare: GetInstanceTypeShare(deploy.GetAnnotations(), AnnotationSpotMaxInstanceTypeShare),
 	}, nil
 }
+
 func GetWaterLevel(annotations map[string]string, key string) int {
 	if value, ok := annotations[key]; ok {
 		v, err := strconv.Atoi(value)
@@ -282,7 +835,17 @@ func GetWaterLevel(annotations map[string]string, key string) int {
 	return 0
 }
 
+// ScheduleCompensation returns the boolean of the annotation key, or nil if it
+// is missing or not a boolean.
+//
+// Deprecated: use GetBoolAnnotation.
 func ScheduleCompensation(annotations map[string]string, key string) *bool {
+	return GetBoolAnnotation(annotations, key)
+}
+
+// GetBoolAnnotation returns the boolean of the annotation key, or nil if it is
+// missing or not a boolean.
+func GetBoolAnnotation(annotations map[string]string, key string) *bool {
 	if value, ok := annotations[key]; ok {
 		v, err := strconv.ParseBool(value)
 		if err != nil {
@@ -292,3 +855,13 @@ func ScheduleCompensation(annotations map[string]string, key string) *bool {
 	}
 	return nil
 }
+
+// GetFallbackTier returns the tier named by the annotation key, or an empty
+// string if it is missing or not a known tier.
+func GetFallbackTier(annotations map[string]string, key string) string {
+	switch value := annotations[key]; value {
+	case OnDemandValue, SpotValue:
+		return value
+	}
+	return ""
+}
@@ -4,15 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	coorv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
 )
 
 type fakeMutationDecoder struct {
@@ -148,3 +160,436 @@ func TestMutatingAdmission_Handle_FullCoverage(t *testing.T) {
 		t.Errorf("Handle() got.Allowed = false, want true")
 	}
 }
+
+// newWorkloadObjects builds a Deployment with the given annotations together with
+// its ReplicaSet and a new pod of it.
+func newWorkloadObjects(annotations map[string]string) (*appsv1.Deployment, *appsv1.ReplicaSet, *corev1.Pod) {
+	controller := true
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "test-namespace",
+			UID:         "deploy-uid",
+			Annotations: annotations,
+		},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-5d4f8",
+			Namespace: "test-namespace",
+			UID:       "rs-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: deploy.Name, UID: deploy.UID, Controller: &controller},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:         "app-5d4f8-x2k9z",
+			GenerateName: "app-5d4f8-",
+			Namespace:    "test-namespace",
+			Labels:       map[string]string{"app": "app", "pod-template-hash": "5d4f8"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rs.Name, UID: rs.UID, Controller: &controller},
+			},
+		},
+	}
+	return deploy, rs, pod
+}
+
+// newOnDemandPod returns an existing pod of the workload that was placed on the on-demand tier.
+func newOnDemandPod(template *corev1.Pod, name string) *corev1.Pod {
+	pod := template.DeepCopy()
+	pod.Name = name
+	(&MutatingAdmission{}).ensureOnDemandNodeAffinityOfPod(pod)
+	return pod
+}
+
+// placedTier infers the tier chosen by the webhook from the pod deletion cost it patched in.
+func placedTier(t *testing.T, resp admission.Response) string {
+	t.Helper()
+	for _, p := range resp.Patches {
+		if !strings.HasPrefix(p.Path, "/metadata/annotations") {
+			continue
+		}
+		raw, err := json.Marshal(p.Value)
+		if err != nil {
+			t.Fatalf("Failed to marshal patch value: %v", err)
+		}
+		switch {
+		case strings.Contains(string(raw), `"20000"`):
+			return OnDemandValue
+		case strings.Contains(string(raw), `"100"`):
+			return SpotValue
+		}
+	}
+	return ""
+}
+
+func TestMutatingAdmission_Handle_Placement(t *testing.T) {
+	strategy := map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	}
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		onDemandPods  int
+		maxConcurrent int
+		saturated     bool
+		budget        time.Duration
+		leaseHeld     bool
+		wantTier      string
+	}{
+		{
+			name:        "not opted in",
+			annotations: map[string]string{},
+			wantTier:    "",
+		},
+		{
+			name:         "below low water",
+			annotations:  strategy,
+			onDemandPods: 1,
+			wantTier:     OnDemandValue,
+		},
+		{
+			name:         "low water reached",
+			annotations:  strategy,
+			onDemandPods: 2,
+			wantTier:     SpotValue,
+		},
+		{
+			name:          "saturated falls back without counting",
+			annotations:   strategy,
+			maxConcurrent: 1,
+			saturated:     true,
+			wantTier:      defaultFallbackTier,
+		},
+		{
+			name:        "budget exceeded while the lease is held elsewhere",
+			annotations: strategy,
+			budget:      100 * time.Millisecond,
+			leaseHeld:   true,
+			wantTier:    defaultFallbackTier,
+		},
+		{
+			name: "budget exceeded uses the strategy fallback tier",
+			annotations: map[string]string{
+				AnnotationScheduleCompensation: "true",
+				AnnotationLowWaterLevel:        "2",
+				AnnotationHighWaterLevel:       "5",
+				AnnotationFallbackTier:         OnDemandValue,
+			},
+			budget:    100 * time.Millisecond,
+			leaseHeld: true,
+			wantTier:  OnDemandValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy, rs, pod := newWorkloadObjects(tt.annotations)
+			objects := []runtime.Object{deploy, rs}
+			for i := 0; i < tt.onDemandPods; i++ {
+				objects = append(objects, newOnDemandPod(pod, fmt.Sprintf("existing-%d", i)))
+			}
+			if tt.leaseHeld {
+				objects = append(objects, &coorv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{Name: leaseName(pod), Namespace: pod.Namespace},
+				})
+			}
+			raw, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("Failed to marshal pod: %v", err)
+			}
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Namespace: pod.Namespace,
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			m := &MutatingAdmission{
+				Decoder:                &fakeMutationDecoder{obj: pod},
+				Client:                 fake.NewSimpleClientset(objects...),
+				MaxConcurrentDecisions: tt.maxConcurrent,
+				DecisionBudget:         tt.budget,
+			}
+			if tt.saturated {
+				m.initOnce.Do(m.init)
+				m.slots <- struct{}{}
+			}
+
+			got := m.Handle(context.Background(), req)
+			if !got.Allowed {
+				t.Fatalf("Handle() got.Allowed = false: %v", got.Result)
+			}
+			if tier := placedTier(t, got); tier != tt.wantTier {
+				t.Errorf("Handle() placed pod on %q, want %q", tier, tt.wantTier)
+			}
+			if tt.leaseHeld {
+				return
+			}
+			if _, err := m.Client.CoordinationV1().Leases(pod.Namespace).Get(context.Background(), leaseName(pod), metav1.GetOptions{}); err == nil {
+				t.Errorf("workload lease was not released")
+			}
+		})
+	}
+}
+
+func TestMutatingAdmission_Handle_BreakerOpen(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	client := fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0"))
+	m := &MutatingAdmission{
+		Decoder: &fakeMutationDecoder{obj: pod},
+		Client:  client,
+		Breaker: NewAPIServerBreaker(circuitbreaker.Options{
+			Window:               time.Minute,
+			MinRequests:          1,
+			FailureRateThreshold: 0.1,
+			OpenDuration:         time.Hour,
+		}),
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	// The first admission is counted normally and fills the cache.
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != OnDemandValue {
+		t.Fatalf("counted admission placed pod on %q, want %q", tier, OnDemandValue)
+	}
+
+	// The API server starts failing, which trips the breaker.
+	client.PrependReactor("get", "replicasets", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("overloaded")
+	})
+	if got := m.Handle(context.Background(), req); got.Allowed {
+		t.Fatalf("Handle() allowed the request that tripped the breaker")
+	}
+	if got := metricValue(t, apiserverBreakerState); got != float64(circuitbreaker.Open) {
+		t.Fatalf("breaker state gauge = %v, want %v while the breaker is open", got, float64(circuitbreaker.Open))
+	}
+	if err := m.BreakerCheck(nil); err == nil {
+		t.Fatalf("BreakerCheck() = nil, want an error while the breaker is open")
+	}
+
+	// While open, the cached counts include the pod placed above.
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != SpotValue {
+		t.Errorf("cached admission placed pod on %q, want %q", tier, SpotValue)
+	}
+
+	// Workloads that were never counted are admitted unmutated.
+	other := pod.DeepCopy()
+	other.GenerateName = "other-7c9d-"
+	m.Decoder = &fakeMutationDecoder{obj: other}
+	got := m.Handle(context.Background(), req)
+	if !got.Allowed || len(got.Patches) != 0 {
+		t.Errorf("Handle() = %v, want an unmutated admission", got)
+	}
+}
+
+func TestMutatingAdmission_Call_CallerDone(t *testing.T) {
+	m := &MutatingAdmission{Breaker: NewAPIServerBreaker(circuitbreaker.Options{
+		Window:               time.Minute,
+		MinRequests:          1,
+		FailureRateThreshold: 0.1,
+		OpenDuration:         time.Hour,
+	})}
+
+	// A decision that ran out of its budget makes no more calls.
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	called := false
+	if err := m.call(expired, func() error { called = true; return nil }); !errors.Is(err, context.DeadlineExceeded) || called {
+		t.Errorf("call() with an expired budget = %v, called %v, want %v without calling", err, called, context.DeadlineExceeded)
+	}
+
+	// A call that outlives its decision is not held against the API server.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.call(ctx, func() error { <-ctx.Done(); return ctx.Err() }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call() outliving its decision = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if state := m.Breaker.State(); state != circuitbreaker.Closed {
+		t.Fatalf("breaker state = %s after the decision budget ran out, want %s", state, circuitbreaker.Closed)
+	}
+
+	// A timeout of the API server itself still trips the breaker.
+	_ = m.call(context.Background(), func() error { return context.DeadlineExceeded })
+	if state := m.Breaker.State(); state != circuitbreaker.Open {
+		t.Errorf("breaker state = %s after an API server timeout, want %s", state, circuitbreaker.Open)
+	}
+}
+
+func TestMutatingAdmission_Handle_Policy(t *testing.T) {
+	policy := config.DefaultPolicy()
+	policy.Tiers[OnDemandValue] = config.Tier{NodeLabelKey: "example.com/pool", NodeLabelValue: "reserved", DeletionCost: ptr.To[int32](7)}
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	// Only pods placed with the on-demand label of the policy are counted.
+	m := &MutatingAdmission{Decoder: &fakeMutationDecoder{obj: pod}}
+	m.SetPolicy(policy)
+	existing := pod.DeepCopy()
+	existing.Name = "existing-0"
+	m.ensureOnDemandNodeAffinityOfPod(existing)
+	m.Client = fake.NewSimpleClientset(deploy, rs, existing, newOnDemandPod(pod, "existing-1"))
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	got := m.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !got.Allowed {
+		t.Fatalf("Handle() got.Allowed = false: %v", got.Result)
+	}
+	patches, err := json.Marshal(got.Patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"example.com/pool"`, `"reserved"`, `"7"`} {
+		if !strings.Contains(string(patches), want) {
+			t.Errorf("Handle() patches = %s, want them to contain %s", patches, want)
+		}
+	}
+}
+
+func TestMutatingAdmission_SetPolicy_Relabel(t *testing.T) {
+	_, _, template := newWorkloadObjects(nil)
+	m := &MutatingAdmission{}
+	m.SetPolicy(config.DefaultPolicy())
+	before := newOnDemandPod(template, "before")
+
+	// A reload relabels the on-demand tier while its pods still run.
+	policy := config.DefaultPolicy()
+	policy.Tiers[OnDemandValue] = config.Tier{NodeLabelKey: "example.com/pool", NodeLabelValue: "reserved"}
+	m.SetPolicy(policy)
+	after := template.DeepCopy()
+	after.Name = "after"
+	m.ensureOnDemandNodeAffinityOfPod(after)
+	spot := template.DeepCopy()
+	spot.Name = "spot"
+	m.ensureSpotNodeAffinityOfPod(spot)
+
+	pods := &corev1.PodList{Items: []corev1.Pod{*before, *after, *spot}}
+	if got := m.countOnDemandPod(pods); got != 2 {
+		t.Errorf("countOnDemandPod() = %d after relabelling, want the pods placed under either label", got)
+	}
+}
+
+func TestMutatingAdmission_Handle_RetriedRequest(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	client := fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0"))
+	leaseCreates, podLists := 0, 0
+	client.PrependReactor("create", "leases", func(clienttesting.Action) (bool, runtime.Object, error) {
+		leaseCreates++
+		return false, nil, nil
+	})
+	client.PrependReactor("list", "pods", func(clienttesting.Action) (bool, runtime.Object, error) {
+		podLists++
+		return false, nil, nil
+	})
+	m := &MutatingAdmission{
+		Decoder:           &fakeMutationDecoder{obj: pod},
+		Client:            client,
+		DecisionCacheSize: 10,
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UID:       "retried-uid",
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	first := m.Handle(context.Background(), req)
+	if tier := placedTier(t, first); tier != OnDemandValue {
+		t.Fatalf("Handle() placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	// The pod admitted first is now stored, so counting again would reach the
+	// low water level and place the retry on spot.
+	admitted := newOnDemandPod(pod, "admitted")
+	if _, err := client.CoreV1().Pods(pod.Namespace).Create(context.Background(), admitted, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create the admitted pod: %v", err)
+	}
+	leaseCreates, podLists = 0, 0
+
+	retry := m.Handle(context.Background(), req)
+	if !reflect.DeepEqual(first, retry) {
+		t.Errorf("retried request got %v, want the original %v", retry, first)
+	}
+	if leaseCreates != 0 || podLists != 0 {
+		t.Errorf("retried request created %d Leases and listed pods %d times, want neither", leaseCreates, podLists)
+	}
+	counts, _, _ := m.counts.get(lockKey(pod), time.Minute)
+	if counts.OnDemand != 2 {
+		t.Errorf("cached on-demand count = %d, want 2: the retry must not be counted again", counts.OnDemand)
+	}
+}
+
+func TestMutatingAdmission_Handle_RetriedRequestOtherReplica(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	client := fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0"))
+	replica := func() *MutatingAdmission {
+		return &MutatingAdmission{Decoder: &fakeMutationDecoder{obj: pod}, Client: client, DecisionCacheSize: 10}
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UID:       "retried-uid",
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	if tier := placedTier(t, replica().Handle(context.Background(), req)); tier != OnDemandValue {
+		t.Fatalf("Handle() placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	if _, err := client.CoreV1().Pods(pod.Namespace).Create(context.Background(), newOnDemandPod(pod, "admitted"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create the admitted pod: %v", err)
+	}
+
+	// The decisions are remembered per replica: a retry served by another one
+	// is counted again, and lands on spot now that the floor is met.
+	if tier := placedTier(t, replica().Handle(context.Background(), req)); tier != SpotValue {
+		t.Errorf("retry on another replica placed pod on %q, want %q", tier, SpotValue)
+	}
+}
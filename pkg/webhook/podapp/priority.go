@@ -0,0 +1,103 @@
+package podapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// priorityClassTTL is how long a looked up PriorityClass, or its absence, is
+// trusted before it is looked up again.
+const priorityClassTTL = time.Minute
+
+// priorityClasses caches the PriorityClasses named by the tiers, so that they
+// are checked before use without a lookup per admission.
+type priorityClasses struct {
+	mu      sync.Mutex
+	entries map[string]priorityClassEntry
+}
+
+type priorityClassEntry struct {
+	// class is nil if the PriorityClass does not exist.
+	class   *schedulingv1.PriorityClass
+	fetched time.Time
+}
+
+func newPriorityClasses() *priorityClasses {
+	return &priorityClasses{entries: map[string]priorityClassEntry{}}
+}
+
+// get returns the PriorityClass name, looked up with fetch once the cached one
+// is older than priorityClassTTL. The stale PriorityClass is kept if the lookup
+// fails for another reason than its absence.
+func (c *priorityClasses) get(name string, now time.Time, fetch func() (*schedulingv1.PriorityClass, error)) (*schedulingv1.PriorityClass, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && now.Sub(entry.fetched) < priorityClassTTL {
+		return entry.class, nil
+	}
+
+	class, err := fetch()
+	switch {
+	case apierrors.IsNotFound(err):
+		class = nil
+	case err != nil:
+		return entry.class, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = priorityClassEntry{class: class, fetched: now}
+	return class, nil
+}
+
+// priorityClass returns the PriorityClass name, or nil if it does not exist or
+// cannot be looked up.
+func (a *MutatingAdmission) priorityClass(ctx context.Context, name string) *schedulingv1.PriorityClass {
+	class, err := a.priorities.get(name, time.Now(), func() (class *schedulingv1.PriorityClass, err error) {
+		err = a.call(ctx, func() error {
+			class, err = a.Client.SchedulingV1().PriorityClasses().Get(ctx, name, metav1.GetOptions{})
+			return err
+		})
+		return class, err
+	})
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to look up PriorityClass", "priorityClass", name)
+	}
+	return class
+}
+
+// ensurePriorityAndScheduler sets the PriorityClass and the scheduler profile of
+// tier on pod, unless it names its own. The default scheduler, and the global
+// default PriorityClass given by the Priority admission plugin, count as unset.
+// The priority and preemption policy resolved by that plugin are updated along
+// with the PriorityClass, which must exist.
+func (a *MutatingAdmission) ensurePriorityAndScheduler(ctx context.Context, t string, pod *corev1.Pod) {
+	tier := a.currentPolicy().Tiers[t]
+	if tier.SchedulerName != "" && (pod.Spec.SchedulerName == "" || pod.Spec.SchedulerName == corev1.DefaultSchedulerName) {
+		pod.Spec.SchedulerName = tier.SchedulerName
+	}
+
+	if tier.PriorityClassName == "" || tier.PriorityClassName == pod.Spec.PriorityClassName {
+		return
+	}
+	if pod.Spec.PriorityClassName != "" {
+		if current := a.priorityClass(ctx, pod.Spec.PriorityClassName); current == nil || !current.GlobalDefault {
+			return
+		}
+	}
+	class := a.priorityClass(ctx, tier.PriorityClassName)
+	if class == nil {
+		klog.FromContext(ctx).Info("Not setting a missing PriorityClass", "tier", t, "priorityClass", tier.PriorityClassName)
+		return
+	}
+	pod.Spec.PriorityClassName = class.Name
+	pod.Spec.Priority = &class.Value
+	pod.Spec.PreemptionPolicy = class.PreemptionPolicy
+}
@@ -0,0 +1,121 @@
+package podapp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+)
+
+func TestMutatingAdmission_EnsurePriorityAndScheduler(t *testing.T) {
+	never := corev1.PreemptNever
+	client := fake.NewSimpleClientset(
+		&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "floor"}, Value: 1000},
+		&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Value: -10, PreemptionPolicy: &never},
+		&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "cluster-default"}, Value: 0, GlobalDefault: true},
+		&schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "mine"}, Value: 5},
+	)
+	policy := config.DefaultPolicy()
+	onDemand, spot := policy.Tiers[OnDemandValue], policy.Tiers[SpotValue]
+	onDemand.PriorityClassName = "floor"
+	spot.PriorityClassName, spot.SchedulerName = "batch", "bin-packing"
+	policy.Tiers[OnDemandValue], policy.Tiers[SpotValue] = onDemand, spot
+	a := &MutatingAdmission{Client: client}
+	a.initOnce.Do(a.init)
+	a.SetPolicy(policy)
+
+	tests := []struct {
+		name         string
+		tier         string
+		spec         corev1.PodSpec
+		wantClass    string
+		wantPriority *int32
+		wantPreempt  *corev1.PreemptionPolicy
+		wantSched    string
+	}{
+		{
+			name:         "on-demand pod without a class",
+			tier:         OnDemandValue,
+			spec:         corev1.PodSpec{SchedulerName: corev1.DefaultSchedulerName},
+			wantClass:    "floor",
+			wantPriority: ptr.To[int32](1000),
+			wantSched:    corev1.DefaultSchedulerName,
+		},
+		{
+			name:         "spot pod with the global default class and scheduler",
+			tier:         SpotValue,
+			spec:         corev1.PodSpec{SchedulerName: corev1.DefaultSchedulerName, PriorityClassName: "cluster-default", Priority: ptr.To[int32](0)},
+			wantClass:    "batch",
+			wantPriority: ptr.To[int32](-10),
+			wantPreempt:  &never,
+			wantSched:    "bin-packing",
+		},
+		{
+			name:         "spot pod with its own class and scheduler",
+			tier:         SpotValue,
+			spec:         corev1.PodSpec{SchedulerName: "custom", PriorityClassName: "mine", Priority: ptr.To[int32](5)},
+			wantClass:    "mine",
+			wantPriority: ptr.To[int32](5),
+			wantSched:    "custom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: tt.spec}
+			a.ensurePriorityAndScheduler(context.TODO(), tt.tier, pod)
+			if pod.Spec.PriorityClassName != tt.wantClass || !ptr.Equal(pod.Spec.Priority, tt.wantPriority) || !ptr.Equal(pod.Spec.PreemptionPolicy, tt.wantPreempt) {
+				t.Errorf("priority = %s %v %v, want %s %v %v", pod.Spec.PriorityClassName, ptr.Deref(pod.Spec.Priority, 0), pod.Spec.PreemptionPolicy,
+					tt.wantClass, ptr.Deref(tt.wantPriority, 0), tt.wantPreempt)
+			}
+			if pod.Spec.SchedulerName != tt.wantSched {
+				t.Errorf("schedulerName = %s, want %s", pod.Spec.SchedulerName, tt.wantSched)
+			}
+		})
+	}
+
+	// A missing PriorityClass is not set.
+	onDemand.PriorityClassName = "missing"
+	policy.Tiers[OnDemandValue] = onDemand
+	a.SetPolicy(policy)
+	pod := &corev1.Pod{}
+	a.ensurePriorityAndScheduler(context.TODO(), OnDemandValue, pod)
+	if pod.Spec.PriorityClassName != "" || pod.Spec.Priority != nil {
+		t.Errorf("priority = %s %v, want none for a missing PriorityClass", pod.Spec.PriorityClassName, pod.Spec.Priority)
+	}
+}
+
+func TestPriorityClasses_Get(t *testing.T) {
+	c := newPriorityClasses()
+	now := time.Now()
+	floor := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "floor"}, Value: 1000}
+	notFound := func() (*schedulingv1.PriorityClass, error) {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: "scheduling.k8s.io", Resource: "priorityclasses"}, "floor")
+	}
+	found := func() (*schedulingv1.PriorityClass, error) { return floor, nil }
+	failed := func() (*schedulingv1.PriorityClass, error) { return nil, errors.New("unavailable") }
+
+	if class, err := c.get("floor", now, notFound); class != nil || err != nil {
+		t.Fatalf("get() = %v, %v; want a missing PriorityClass", class, err)
+	}
+	// The absence is trusted until the TTL runs out.
+	if class, _ := c.get("floor", now.Add(priorityClassTTL/2), found); class != nil {
+		t.Errorf("get() = %v before the TTL, want the cached absence", class)
+	}
+	if class, _ := c.get("floor", now.Add(priorityClassTTL), found); class != floor {
+		t.Errorf("get() = %v after the TTL, want the created PriorityClass", class)
+	}
+	// A failed lookup keeps the stale PriorityClass.
+	if class, err := c.get("floor", now.Add(3*priorityClassTTL), failed); class != floor || err == nil {
+		t.Errorf("get() = %v, %v; want the stale PriorityClass and the error", class, err)
+	}
+}
@@ -0,0 +1,118 @@
+package podapp
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/version"
+)
+
+// AnnotationPlacement holds the Placement of a mutated pod as JSON, so that a
+// placement can be audited from the pod alone.
+const AnnotationPlacement = "webhook-demo.com/placement"
+
+// Rules that decide the tier of a pod.
+const (
+	// RuleBelowLowWater places the pod on on-demand because the workload has fewer
+	// on-demand pods than its low water level.
+	RuleBelowLowWater = "below-low-water"
+	// RuleBelowZoneFloor places the pod on on-demand in Placement.Zone because the
+	// zone has fewer on-demand pods of the workload than its share of the low
+	// water level.
+	RuleBelowZoneFloor = "below-zone-floor"
+	// RuleBetweenLevels places the pod on spot because the low water level is
+	// reached while the workload is below its high water level.
+	RuleBetweenLevels = "between-levels"
+	// RuleAboveHighWater places the pod on spot because the workload has reached
+	// its high water level.
+	RuleAboveHighWater = "above-high-water"
+	// RuleFallback places the pod on the fallback tier without counting the workload.
+	RuleFallback = "fallback"
+)
+
+// Placement is the provenance of a placement decision.
+type Placement struct {
+	// Tier is the tier the pod was placed on.
+	Tier string `json:"tier"`
+	// Rule is the rule that chose Tier.
+	Rule string `json:"rule"`
+	// Reason is why the workload was not counted. Only set with RuleFallback.
+	Reason string `json:"reason,omitempty"`
+	// Zone is the zone the pod was pinned to. Only set with RuleBelowZoneFloor.
+	Zone string `json:"zone,omitempty"`
+	// InstanceTypes are the weights of the instance types a diversified spot pod
+	// prefers, and CappedInstanceTypes the ones whose spot nodes it avoids.
+	InstanceTypes       InstanceTypeWeights `json:"instanceTypes,omitempty"`
+	CappedInstanceTypes []string            `json:"cappedInstanceTypes,omitempty"`
+	// OnDemand and Spot are the pods of the workload on each tier before the
+	// placement. Unset with RuleFallback.
+	OnDemand *int `json:"onDemand,omitempty"`
+	Spot     *int `json:"spot,omitempty"`
+	// Cached reports that the counts were cached ones, used while the API server
+	// circuit breaker is open.
+	Cached bool `json:"cached,omitempty"`
+	// Generation is the generation of the Deployment the strategy was read from.
+	Generation int64 `json:"generation"`
+	// Version is the version of the webhook that made the decision.
+	Version string `json:"version"`
+}
+
+// newPlacement decides the tier of a pod from the counts of its workload.
+func newPlacement(strategy *UserStrategy, onDemand, spot int) Placement {
+	p := Placement{
+		Tier:       SpotValue,
+		OnDemand:   ptr.To(onDemand),
+		Spot:       ptr.To(spot),
+		Generation: strategy.Generation,
+		Version:    version.Get().GitVersion,
+	}
+	switch {
+	case onDemand < strategy.LowWaterLevel:
+		p.Tier, p.Rule = OnDemandValue, RuleBelowLowWater
+	case onDemand+spot < strategy.HighWaterLevel:
+		p.Rule = RuleBetweenLevels
+	default:
+		p.Rule = RuleAboveHighWater
+	}
+	return p
+}
+
+func newFallbackPlacement(strategy *UserStrategy, tier, reason string) Placement {
+	return Placement{
+		Tier:       tier,
+		Rule:       RuleFallback,
+		Reason:     reason,
+		Generation: strategy.Generation,
+		Version:    version.Get().GitVersion,
+	}
+}
+
+// String returns a short explanation of the placement, such as
+// "on-demand by below-low-water" or "spot by fallback: deadline".
+func (p Placement) String() string {
+	s := p.Tier + " by " + p.Rule
+	if p.Reason != "" {
+		s += ": " + p.Reason
+	}
+	if p.Zone != "" {
+		s += " in " + p.Zone
+	}
+	if p.Cached {
+		s += " from cached counts"
+	}
+	return s
+}
+
+func setPlacementAnnotation(pod *corev1.Pod, p Placement) error {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[AnnotationPlacement] = string(raw)
+	return nil
+}
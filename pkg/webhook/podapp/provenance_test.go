@@ -0,0 +1,84 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestNewPlacement(t *testing.T) {
+	strategy := &UserStrategy{LowWaterLevel: 2, HighWaterLevel: 5, Generation: 3}
+	tests := []struct {
+		name     string
+		onDemand int
+		spot     int
+		wantTier string
+		wantRule string
+	}{
+		{name: "below low water", onDemand: 1, spot: 4, wantTier: OnDemandValue, wantRule: RuleBelowLowWater},
+		{name: "between levels", onDemand: 2, spot: 2, wantTier: SpotValue, wantRule: RuleBetweenLevels},
+		{name: "high water reached", onDemand: 2, spot: 3, wantTier: SpotValue, wantRule: RuleAboveHighWater},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPlacement(strategy, tt.onDemand, tt.spot)
+			if p.Tier != tt.wantTier || p.Rule != tt.wantRule {
+				t.Errorf("newPlacement() = %s by %s, want %s by %s", p.Tier, p.Rule, tt.wantTier, tt.wantRule)
+			}
+			if *p.OnDemand != tt.onDemand || *p.Spot != tt.spot || p.Generation != 3 {
+				t.Errorf("newPlacement() = %+v, want the observed counts and generation 3", p)
+			}
+		})
+	}
+}
+
+func TestMutatingAdmission_Handle_PlacementAnnotation(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+	})
+	deploy.Generation = 7
+	m := &MutatingAdmission{
+		Decoder: &fakeMutationDecoder{obj: pod},
+		Client:  fake.NewSimpleClientset(deploy, rs, newOnDemandPod(pod, "existing-0")),
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	resp := m.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+
+	var annotations map[string]string
+	for _, p := range resp.Patches {
+		if p.Path != "/metadata/annotations" {
+			continue
+		}
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			t.Fatalf("Failed to marshal patch value: %v", err)
+		}
+		if err := json.Unmarshal(value, &annotations); err != nil {
+			t.Fatalf("Failed to unmarshal annotations: %v", err)
+		}
+	}
+	var got Placement
+	if err := json.Unmarshal([]byte(annotations[AnnotationPlacement]), &got); err != nil {
+		t.Fatalf("Failed to unmarshal %s annotation %q: %v", AnnotationPlacement, annotations[AnnotationPlacement], err)
+	}
+	if got.Tier != OnDemandValue || got.Rule != RuleBelowLowWater || got.OnDemand == nil || *got.OnDemand != 1 ||
+		got.Generation != 7 || got.Version == "" {
+		t.Errorf("placement annotation = %+v, want on-demand below low water from 1 on-demand pod at generation 7", got)
+	}
+}
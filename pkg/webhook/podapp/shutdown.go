@@ -0,0 +1,66 @@
+package podapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// drainPollInterval is how often Drain checks for in-flight admissions.
+const drainPollInterval = 50 * time.Millisecond
+
+// StartDrain makes DrainCheck fail, so that the replica is taken out of the
+// Service endpoints while it keeps serving the requests already routed to it.
+func (a *MutatingAdmission) StartDrain() {
+	a.draining.Store(true)
+}
+
+// DrainCheck is a healthz.Checker that fails once the replica started draining.
+func (a *MutatingAdmission) DrainCheck(_ *http.Request) error {
+	if a.draining.Load() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// Drain blocks until no admission is in flight or ctx is done.
+func (a *MutatingAdmission) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := a.inflight.Load()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d admissions still in flight: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseHeldLocks deletes every workload Lease this replica still owns, each
+// with a deadline derived from a fresh context rather than the cancelled
+// request context, and logs the ones that could not be released.
+func (a *MutatingAdmission) ReleaseHeldLocks(ctx context.Context) {
+	a.initOnce.Do(a.init)
+	leases := a.held.list()
+	for _, key := range sortedKeys(leases) {
+		lease := leases[key]
+		releaseCtx, cancel := context.WithTimeout(ctx, lockReleaseTimeout)
+		err := a.deleteLease(releaseCtx, key, lease.Namespace, lease.Name)
+		cancel()
+		if err == nil {
+			klog.Infof("Released lease %s held for %s on shutdown", key, time.Since(lease.AcquiredAt).Round(time.Millisecond))
+		}
+	}
+
+	for _, key := range sortedKeys(a.held.list()) {
+		klog.Warningf("Lease %s is still owned by %s after shutdown", key, a.identity)
+	}
+}
@@ -0,0 +1,48 @@
+package podapp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMutatingAdmission_DrainAndReleaseHeldLocks(t *testing.T) {
+	_, _, pod := newWorkloadObjects(nil)
+	m := &MutatingAdmission{Client: fake.NewSimpleClientset()}
+	m.initOnce.Do(m.init)
+
+	if err := m.DrainCheck(nil); err != nil {
+		t.Fatalf("DrainCheck() = %v before draining", err)
+	}
+	m.StartDrain()
+	if err := m.DrainCheck(nil); err == nil {
+		t.Fatalf("DrainCheck() = nil while draining")
+	}
+
+	// An admission is in flight and holds the workload lock when the server stops.
+	m.inflight.Add(1)
+	if err := m.tryAcquireLock(context.Background(), pod); err != nil {
+		t.Fatalf("tryAcquireLock() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := m.Drain(ctx); err == nil {
+		t.Fatalf("Drain() = nil with an admission in flight")
+	}
+
+	m.ReleaseHeldLocks(context.Background())
+	if _, err := m.Client.CoordinationV1().Leases(pod.Namespace).Get(context.Background(), leaseName(pod), metav1.GetOptions{}); err == nil {
+		t.Errorf("lease %s was not released", leaseName(pod))
+	}
+	if held := m.held.list(); len(held) != 0 {
+		t.Errorf("held leases = %v, want none", held)
+	}
+
+	m.inflight.Add(-1)
+	if err := m.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() error = %v with nothing in flight", err)
+	}
+}
@@ -0,0 +1,158 @@
+package podapp
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AnnotationOnDemandSpread and AnnotationSpotSpread request the spreading of
+	// the pods of a tier, as a comma-separated list of
+	// <topology>[:<maxSkew>[:<whenUnsatisfiable>]] or
+	// <topology>:anti-affinity[:<whenUnsatisfiable>], where topology is zone or
+	// hostname and whenUnsatisfiable is ScheduleAnyway, the default, or
+	// DoNotSchedule. For example "zone:1:DoNotSchedule,hostname:anti-affinity".
+	AnnotationOnDemandSpread string = "webhook-demo.com/on-demand-spread"
+	AnnotationSpotSpread     string = "webhook-demo.com/spot-spread"
+	// LabelTier is set to the tier of the pods that are spread, so that they are
+	// spread among the pods of their tier.
+	LabelTier string = "webhook-demo.com/tier"
+)
+
+// spreadTopologies maps the topologies of the spread annotations to their
+// node labels.
+var spreadTopologies = map[string]string{
+	"zone":     corev1.LabelTopologyZone,
+	"hostname": corev1.LabelHostname,
+}
+
+// spreadAntiAffinity is the maxSkew field of an anti-affinity entry.
+const spreadAntiAffinity = "anti-affinity"
+
+// SpreadConstraint spreads the pods of a tier over a topology.
+type SpreadConstraint struct {
+	// TopologyKey is the node label of the topology.
+	TopologyKey string
+	// MaxSkew is the maxSkew of the topology spread constraint.
+	MaxSkew int32
+	// WhenUnsatisfiable is whether the spreading is required, DoNotSchedule, or
+	// only preferred, ScheduleAnyway.
+	WhenUnsatisfiable corev1.UnsatisfiableConstraintAction
+	// AntiAffinity spreads with pod anti-affinity, at most one pod of the tier
+	// per topology domain, instead of a topology spread constraint.
+	AntiAffinity bool
+}
+
+// GetSpread returns the spread constraints of the annotation key. Malformed
+// entries are ignored.
+func GetSpread(annotations map[string]string, key string) []SpreadConstraint {
+	value, ok := annotations[key]
+	if !ok {
+		return nil
+	}
+	var constraints []SpreadConstraint
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		topologyKey, ok := spreadTopologies[fields[0]]
+		if !ok || len(fields) > 3 {
+			continue
+		}
+		c := SpreadConstraint{TopologyKey: topologyKey, MaxSkew: 1, WhenUnsatisfiable: corev1.ScheduleAnyway}
+		if len(fields) > 1 {
+			if fields[1] == spreadAntiAffinity {
+				c.AntiAffinity = true
+			} else if skew, err := strconv.ParseInt(fields[1], 10, 32); err == nil && skew > 0 {
+				c.MaxSkew = int32(skew)
+			} else {
+				continue
+			}
+		}
+		if len(fields) > 2 {
+			switch action := corev1.UnsatisfiableConstraintAction(fields[2]); action {
+			case corev1.ScheduleAnyway, corev1.DoNotSchedule:
+				c.WhenUnsatisfiable = action
+			default:
+				continue
+			}
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints
+}
+
+// ensureSpread spreads pod with the other pods of tier of its workload as
+// requested by strategy. A topology the pod already spreads over, or has anti
+// affinity on, is left to the pod.
+func (a *MutatingAdmission) ensureSpread(tier string, strategy *UserStrategy, pod *corev1.Pod) {
+	constraints := strategy.Spread[tier]
+	if len(constraints) == 0 || strategy.Selector == nil {
+		return
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[LabelTier] = tier
+	selector := strategy.Selector.DeepCopy()
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	selector.MatchLabels[LabelTier] = tier
+
+	for _, c := range constraints {
+		if c.AntiAffinity {
+			ensurePodAntiAffinity(pod, c, selector)
+			continue
+		}
+		if hasTopologySpread(pod, c.TopologyKey) {
+			continue
+		}
+		pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           c.MaxSkew,
+			TopologyKey:       c.TopologyKey,
+			WhenUnsatisfiable: c.WhenUnsatisfiable,
+			LabelSelector:     selector,
+		})
+	}
+}
+
+func hasTopologySpread(pod *corev1.Pod, topologyKey string) bool {
+	for _, c := range pod.Spec.TopologySpreadConstraints {
+		if c.TopologyKey == topologyKey {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePodAntiAffinity adds a required anti-affinity term for DoNotSchedule and
+// a preferred one otherwise.
+func ensurePodAntiAffinity(pod *corev1.Pod, c SpreadConstraint, selector *metav1.LabelSelector) {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.PodAntiAffinity == nil {
+		pod.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+	antiAffinity := pod.Spec.Affinity.PodAntiAffinity
+	for _, term := range antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if term.TopologyKey == c.TopologyKey {
+			return
+		}
+	}
+	for _, term := range antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if term.PodAffinityTerm.TopologyKey == c.TopologyKey {
+			return
+		}
+	}
+
+	term := corev1.PodAffinityTerm{LabelSelector: selector, TopologyKey: c.TopologyKey}
+	if c.WhenUnsatisfiable == corev1.DoNotSchedule {
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, term)
+		return
+	}
+	antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.WeightedPodAffinityTerm{Weight: 100, PodAffinityTerm: term})
+}
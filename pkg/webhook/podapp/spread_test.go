@@ -0,0 +1,116 @@
+package podapp
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSpread(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []SpreadConstraint
+	}{
+		{
+			value: "zone",
+			want:  []SpreadConstraint{{TopologyKey: corev1.LabelTopologyZone, MaxSkew: 1, WhenUnsatisfiable: corev1.ScheduleAnyway}},
+		},
+		{
+			value: "zone:2:DoNotSchedule, hostname:anti-affinity",
+			want: []SpreadConstraint{
+				{TopologyKey: corev1.LabelTopologyZone, MaxSkew: 2, WhenUnsatisfiable: corev1.DoNotSchedule},
+				{TopologyKey: corev1.LabelHostname, MaxSkew: 1, WhenUnsatisfiable: corev1.ScheduleAnyway, AntiAffinity: true},
+			},
+		},
+		{
+			value: "hostname:anti-affinity:DoNotSchedule",
+			want:  []SpreadConstraint{{TopologyKey: corev1.LabelHostname, MaxSkew: 1, WhenUnsatisfiable: corev1.DoNotSchedule, AntiAffinity: true}},
+		},
+		{
+			value: "rack,zone:0,zone:x,zone:1:Sometimes,zone:1:DoNotSchedule:extra",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got := GetSpread(map[string]string{AnnotationSpotSpread: tt.value}, AnnotationSpotSpread)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetSpread() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutatingAdmission_EnsureSpread(t *testing.T) {
+	workload := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}}
+	tierSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app", LabelTier: OnDemandValue}}
+	strategy := &UserStrategy{
+		Selector: workload,
+		Spread: map[string][]SpreadConstraint{
+			OnDemandValue: GetSpread(map[string]string{AnnotationOnDemandSpread: "zone:1:DoNotSchedule,hostname:anti-affinity"}, AnnotationOnDemandSpread),
+		},
+	}
+	userSpread := corev1.TopologySpreadConstraint{MaxSkew: 3, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.ScheduleAnyway}
+
+	tests := []struct {
+		name     string
+		tier     string
+		spec     corev1.PodSpec
+		wantSpec corev1.PodSpec
+		wantTier string
+	}{
+		{
+			name: "injects the constraints of the tier",
+			tier: OnDemandValue,
+			wantSpec: corev1.PodSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+					{MaxSkew: 1, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.DoNotSchedule, LabelSelector: tierSelector},
+				},
+				Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+						{Weight: 100, PodAffinityTerm: corev1.PodAffinityTerm{LabelSelector: tierSelector, TopologyKey: corev1.LabelHostname}},
+					},
+				}},
+			},
+			wantTier: OnDemandValue,
+		},
+		{
+			name: "keeps the constraints of the user",
+			tier: OnDemandValue,
+			spec: corev1.PodSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{userSpread},
+				Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: corev1.LabelHostname}},
+				}},
+			},
+			wantSpec: corev1.PodSpec{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{userSpread},
+				Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: corev1.LabelHostname}},
+				}},
+			},
+			wantTier: OnDemandValue,
+		},
+		{
+			name: "leaves tiers without constraints alone",
+			tier: SpotValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "app"}}, Spec: tt.spec}
+			(&MutatingAdmission{}).ensureSpread(tt.tier, strategy, pod)
+			if !reflect.DeepEqual(pod.Spec, tt.wantSpec) {
+				t.Errorf("spec = %+v, want %+v", pod.Spec, tt.wantSpec)
+			}
+			if pod.Labels[LabelTier] != tt.wantTier {
+				t.Errorf("tier label = %q, want %q", pod.Labels[LabelTier], tt.wantTier)
+			}
+		})
+	}
+	if len(workload.MatchLabels) != 1 {
+		t.Errorf("the selector of the workload was modified: %v", workload)
+	}
+}
@@ -0,0 +1,85 @@
+package podapp
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+// defaultTolerationSeconds is the tolerationSeconds of the not-ready and
+// unreachable tolerations added by the DefaultTolerationSeconds admission
+// plugin, which runs before the webhook. Such tolerations are replaced by the
+// ones of the tier, any other value was set by the user and is kept.
+const defaultTolerationSeconds int64 = 300
+
+// ensureNoExecuteTolerations gives pod the node.kubernetes.io/not-ready and
+// node.kubernetes.io/unreachable NoExecute tolerations of tier, so that pods of
+// a tier that is quickly reclaimed leave a failed node sooner.
+func (a *MutatingAdmission) ensureNoExecuteTolerations(t string, pod *corev1.Pod) {
+	tier, ok := a.currentPolicy().Tiers[t]
+	if !ok {
+		return
+	}
+	ensureNoExecuteToleration(pod, corev1.TaintNodeNotReady, tier.NotReadyTolerationSeconds)
+	ensureNoExecuteToleration(pod, corev1.TaintNodeUnreachable, tier.UnreachableTolerationSeconds)
+}
+
+func ensureNoExecuteToleration(pod *corev1.Pod, key string, seconds *int64) {
+	if seconds == nil {
+		return
+	}
+	for i := range pod.Spec.Tolerations {
+		toleration := &pod.Spec.Tolerations[i]
+		if !toleratesNoExecute(toleration, key) {
+			continue
+		}
+		if toleration.Key == key && toleration.TolerationSeconds != nil && *toleration.TolerationSeconds == defaultTolerationSeconds {
+			toleration.TolerationSeconds = ptr.To(*seconds)
+		}
+		return
+	}
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, corev1.Toleration{
+		Key:               key,
+		Operator:          corev1.TolerationOpExists,
+		Effect:            corev1.TaintEffectNoExecute,
+		TolerationSeconds: ptr.To(*seconds),
+	})
+}
+
+// toleratesNoExecute tells whether toleration covers the NoExecute taint key,
+// which has no value.
+func toleratesNoExecute(toleration *corev1.Toleration, key string) bool {
+	if toleration.Effect != "" && toleration.Effect != corev1.TaintEffectNoExecute {
+		return false
+	}
+	if toleration.Key == "" {
+		return toleration.Operator == corev1.TolerationOpExists
+	}
+	return toleration.Key == key && (toleration.Operator == corev1.TolerationOpExists || toleration.Value == "")
+}
+
+// ensureTaintTolerations gives pod a toleration of every taint of tier it does
+// not already tolerate, so that it can land on the tainted nodes of the tier it
+// requires or prefers. The tolerations of the pod are kept as they are.
+func (a *MutatingAdmission) ensureTaintTolerations(t string, pod *corev1.Pod) {
+	taints := a.currentPolicy().Tiers[t].Taints
+	for i := range taints {
+		taint := &taints[i]
+		if tolerates(pod.Spec.Tolerations, taint) {
+			continue
+		}
+		toleration := corev1.Toleration{Key: taint.Key, Operator: corev1.TolerationOpEqual, Value: taint.Value, Effect: taint.Effect}
+		if taint.Value == "" {
+			toleration.Operator, toleration.Value = corev1.TolerationOpExists, ""
+		}
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+	}
+}
+
+func tolerates(tolerations []corev1.Toleration, taint *corev1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package podapp
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/config"
+)
+
+func noExecute(key string, seconds int64) corev1.Toleration {
+	return corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: ptr.To(seconds)}
+}
+
+func TestMutatingAdmission_EnsureNoExecuteTolerations(t *testing.T) {
+	policy := config.DefaultPolicy()
+	for name, seconds := range map[string]int64{OnDemandValue: 300, SpotValue: 30} {
+		tier := policy.Tiers[name]
+		tier.NotReadyTolerationSeconds, tier.UnreachableTolerationSeconds = ptr.To(seconds), ptr.To(seconds+1)
+		policy.Tiers[name] = tier
+	}
+	a := &MutatingAdmission{}
+	a.SetPolicy(policy)
+
+	tests := []struct {
+		name        string
+		tier        string
+		tolerations []corev1.Toleration
+		want        []corev1.Toleration
+	}{
+		{
+			name: "added to spot pods",
+			tier: SpotValue,
+			want: []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 30), noExecute(corev1.TaintNodeUnreachable, 31)},
+		},
+		{
+			name: "added to on-demand pods",
+			tier: OnDemandValue,
+			want: []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 300), noExecute(corev1.TaintNodeUnreachable, 301)},
+		},
+		{
+			name:        "replaces the defaults of the admission plugin",
+			tier:        SpotValue,
+			tolerations: []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 300), noExecute(corev1.TaintNodeUnreachable, 300)},
+			want:        []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 30), noExecute(corev1.TaintNodeUnreachable, 31)},
+		},
+		{
+			name:        "keeps the tolerations of the user",
+			tier:        SpotValue,
+			tolerations: []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 600), {Key: corev1.TaintNodeUnreachable, Operator: corev1.TolerationOpExists}},
+			want:        []corev1.Toleration{noExecute(corev1.TaintNodeNotReady, 600), {Key: corev1.TaintNodeUnreachable, Operator: corev1.TolerationOpExists}},
+		},
+		{
+			name:        "keeps a toleration of every taint",
+			tier:        SpotValue,
+			tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			want:        []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+		},
+		{
+			name:        "ignores tolerations of other effects",
+			tier:        SpotValue,
+			tolerations: []corev1.Toleration{{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+			want: []corev1.Toleration{
+				{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+				noExecute(corev1.TaintNodeNotReady, 30),
+				noExecute(corev1.TaintNodeUnreachable, 31),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: tt.tolerations}}
+			a.ensureNoExecuteTolerations(tt.tier, pod)
+			if !reflect.DeepEqual(pod.Spec.Tolerations, tt.want) {
+				t.Errorf("tolerations = %+v, want %+v", pod.Spec.Tolerations, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutatingAdmission_EnsureTaintTolerations(t *testing.T) {
+	policy := config.DefaultPolicy()
+	spot := policy.Tiers[SpotValue]
+	spot.Taints = []corev1.Taint{
+		{Key: "spot", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "example.com/reclaimable", Effect: corev1.TaintEffectPreferNoSchedule},
+	}
+	policy.Tiers[SpotValue] = spot
+	a := &MutatingAdmission{}
+	a.SetPolicy(policy)
+
+	spotToleration := corev1.Toleration{Key: "spot", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoSchedule}
+	reclaimableToleration := corev1.Toleration{Key: "example.com/reclaimable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectPreferNoSchedule}
+	userToleration := corev1.Toleration{Key: "spot", Operator: corev1.TolerationOpExists}
+	tests := []struct {
+		name        string
+		tier        string
+		tolerations []corev1.Toleration
+		want        []corev1.Toleration
+	}{
+		{name: "spot pods tolerate the spot taints", tier: SpotValue, want: []corev1.Toleration{spotToleration, reclaimableToleration}},
+		{name: "on-demand pods are left alone", tier: OnDemandValue, want: nil},
+		{
+			name:        "taints tolerated by the user are skipped",
+			tier:        SpotValue,
+			tolerations: []corev1.Toleration{userToleration},
+			want:        []corev1.Toleration{userToleration, reclaimableToleration},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: tt.tolerations}}
+			a.ensureTaintTolerations(tt.tier, pod)
+			if !reflect.DeepEqual(pod.Spec.Tolerations, tt.want) {
+				t.Errorf("tolerations = %+v, want %+v", pod.Spec.Tolerations, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package podapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// AnnotationZoneAwareFloor enforces the low water level of a workload per zone:
+// every zone of the on-demand nodes gets at least ceil(low/zones) on-demand pods,
+// pinned to it by their node affinity.
+const AnnotationZoneAwareFloor string = "webhook-demo.com/zone-aware-floor"
+
+// nodeLabelRefreshInterval is how long the labels of the nodes of a tier are
+// trusted before the nodes are listed again.
+const nodeLabelRefreshInterval = time.Minute
+
+// nodeLabels caches the values of a label, such as the zone, on the nodes of a
+// tier.
+type nodeLabels struct {
+	label string
+
+	mu         sync.Mutex
+	selector   string
+	values     []string
+	nodeValues map[string]string
+	listedAt   time.Time
+}
+
+// get returns the sorted values of the label on the nodes matching selector
+// and the value of every such node, listed with list once the cached ones are
+// older than nodeLabelRefreshInterval or were listed with another selector.
+func (l *nodeLabels) get(selector string, now time.Time, list func() (*corev1.NodeList, error)) ([]string, map[string]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.selector == selector && now.Sub(l.listedAt) < nodeLabelRefreshInterval {
+		return l.values, l.nodeValues, nil
+	}
+	nodes, err := list()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeValues := map[string]string{}
+	valueSet := map[string]bool{}
+	for _, node := range nodes.Items {
+		if value := node.Labels[l.label]; value != "" {
+			nodeValues[node.Name] = value
+			valueSet[value] = true
+		}
+	}
+	values := make([]string, 0, len(valueSet))
+	for value := range valueSet {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	l.selector, l.values, l.nodeValues, l.listedAt = selector, values, nodeValues, now
+	return values, nodeValues, nil
+}
+
+// cached returns the values last listed with selector, however old.
+func (l *nodeLabels) cached(selector string) ([]string, map[string]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.listedAt.IsZero() || l.selector != selector {
+		return nil, nil, false
+	}
+	return l.values, l.nodeValues, true
+}
+
+// tierLabelsFunc returns the values of the label of cache on the nodes of tier,
+// and the value of every such node.
+type tierLabelsFunc func(cache *nodeLabels, tier string) ([]string, map[string]string, error)
+
+// listedTierNodeLabels returns the tierLabelsFunc that lists the nodes within
+// ctx once the cached labels are stale.
+func (a *MutatingAdmission) listedTierNodeLabels(ctx context.Context) tierLabelsFunc {
+	return func(cache *nodeLabels, t string) ([]string, map[string]string, error) {
+		selector := a.tierSelector(t)
+		return cache.get(selector, time.Now(), func() (nodes *corev1.NodeList, err error) {
+			err = a.call(ctx, func() error {
+				nodes, err = a.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector})
+				return err
+			})
+			return nodes, err
+		})
+	}
+}
+
+// cachedTierNodeLabels is a tierLabelsFunc that never lists the nodes: it
+// returns the labels last listed, however old, to place pods while the API
+// server circuit breaker is open.
+func (a *MutatingAdmission) cachedTierNodeLabels(cache *nodeLabels, t string) ([]string, map[string]string, error) {
+	values, nodeValues, ok := cache.cached(a.tierSelector(t))
+	if !ok {
+		return nil, nil, fmt.Errorf("the %s label of the %s nodes was never listed", cache.label, t)
+	}
+	return values, nodeValues, nil
+}
+
+// tierSelector returns the label selector of the nodes of tier.
+func (a *MutatingAdmission) tierSelector(t string) string {
+	tier := a.currentPolicy().Tiers[t]
+	return labels.SelectorFromSet(labels.Set{tier.NodeLabelKey: tier.NodeLabelValue}).String()
+}
+
+// zoneBelowFloor returns the zone of the on-demand nodes that is furthest below
+// its share of the low water level of strategy, given the on-demand pods of the
+// workload in podList. It reports false if every zone has its share, or if the
+// zones cannot be discovered with labels.
+func (a *MutatingAdmission) zoneBelowFloor(ctx context.Context, strategy *UserStrategy, podList *corev1.PodList, labels tierLabelsFunc) (string, bool) {
+	zones, nodeZones, err := labels(a.zones, OnDemandValue)
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to discover the zones of the on-demand nodes, enforcing the floor of the whole workload")
+		return "", false
+	}
+	if len(zones) == 0 {
+		klog.FromContext(ctx).V(2).Info("No zone label on the on-demand nodes, enforcing the floor of the whole workload")
+		return "", false
+	}
+
+	counts := map[string]int{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !a.isOnDemandPod(pod) {
+			continue
+		}
+		zone := nodeZones[pod.Spec.NodeName]
+		if pod.Spec.NodeName == "" {
+			zone = requiredZone(pod)
+		}
+		counts[zone]++
+	}
+	floor := (strategy.LowWaterLevel + len(zones) - 1) / len(zones)
+	best := ""
+	for _, zone := range zones {
+		if counts[zone] < floor && (best == "" || counts[zone] < counts[best]) {
+			best = zone
+		}
+	}
+	return best, best != ""
+}
+
+// requiredZone returns the zone a pod that is not scheduled yet was pinned to,
+// if any.
+func requiredZone(pod *corev1.Pod) string {
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == corev1.LabelTopologyZone && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+				return expr.Values[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+package podapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/audit"
+	"github.com/neteric/101_distributed_scheduling_s1/pkg/util/circuitbreaker"
+)
+
+func newZonedNode(name, tier, zone string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+		OnDemandNodeLabelKey:     tier,
+		corev1.LabelTopologyZone: zone,
+	}}}
+}
+
+func TestMutatingAdmission_ZoneBelowFloor(t *testing.T) {
+	nodes := []runtime.Object{
+		newZonedNode("node-a", OnDemandValue, "zone-a"),
+		newZonedNode("node-b", OnDemandValue, "zone-b"),
+		newZonedNode("node-c", OnDemandValue, "zone-c"),
+		newZonedNode("spot-d", SpotValue, "zone-d"),
+	}
+	_, _, template := newWorkloadObjects(nil)
+	onDemandPod := func(node, zone string) corev1.Pod {
+		pod := template.DeepCopy()
+		(&MutatingAdmission{}).ensureOnDemandNodeAffinityInZone(pod, zone)
+		pod.Spec.NodeName = node
+		return *pod
+	}
+	spotPod := func(node string) corev1.Pod {
+		pod := template.DeepCopy()
+		pod.Spec.NodeName = node
+		return *pod
+	}
+
+	tests := []struct {
+		name     string
+		low      int
+		pods     []corev1.Pod
+		wantZone string
+	}{
+		{name: "empty workload", low: 3, wantZone: "zone-a"},
+		{
+			name:     "scheduled and pinned pods count in their zone",
+			low:      6,
+			pods:     []corev1.Pod{onDemandPod("node-a", ""), onDemandPod("node-a", ""), onDemandPod("", "zone-b"), onDemandPod("node-c", ""), onDemandPod("node-c", "")},
+			wantZone: "zone-b",
+		},
+		{
+			name:     "floor is rounded up",
+			low:      4,
+			pods:     []corev1.Pod{onDemandPod("node-a", ""), onDemandPod("node-a", ""), onDemandPod("node-a", ""), onDemandPod("node-b", ""), onDemandPod("node-c", "")},
+			wantZone: "zone-b",
+		},
+		{
+			name: "every zone has its share",
+			low:  3,
+			pods: []corev1.Pod{onDemandPod("node-a", ""), onDemandPod("node-b", ""), onDemandPod("", "zone-c"), spotPod("spot-d")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &MutatingAdmission{Client: fake.NewSimpleClientset(nodes...)}
+			a.initOnce.Do(a.init)
+			zone, ok := a.zoneBelowFloor(context.TODO(), &UserStrategy{LowWaterLevel: tt.low}, &corev1.PodList{Items: tt.pods}, a.listedTierNodeLabels(context.TODO()))
+			if zone != tt.wantZone || ok != (tt.wantZone != "") {
+				t.Errorf("zoneBelowFloor() = %q, %v; want %q", zone, ok, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestNodeLabels_Get(t *testing.T) {
+	z := &nodeLabels{label: corev1.LabelTopologyZone}
+	now := time.Now()
+	lists := 0
+	list := func(zones ...string) func() (*corev1.NodeList, error) {
+		return func() (*corev1.NodeList, error) {
+			lists++
+			nodes := &corev1.NodeList{}
+			for i, zone := range zones {
+				nodes.Items = append(nodes.Items, *newZonedNode(fmt.Sprintf("node-%d", i), OnDemandValue, zone))
+			}
+			return nodes, nil
+		}
+	}
+
+	zones, _, _ := z.get("tier=on-demand", now, list("zone-b", "zone-a", "zone-b"))
+	if strings.Join(zones, ",") != "zone-a,zone-b" {
+		t.Errorf("zones = %v, want zone-a,zone-b", zones)
+	}
+	if zones, _, _ = z.get("tier=on-demand", now.Add(nodeLabelRefreshInterval/2), list("zone-c")); len(zones) != 2 || lists != 1 {
+		t.Errorf("zones = %v after %d lists, want the cached ones", zones, lists)
+	}
+	if zones, _, _ = z.get("tier=reserved", now.Add(nodeLabelRefreshInterval/2), list("zone-c")); len(zones) != 1 || lists != 2 {
+		t.Errorf("zones = %v after %d lists, want a list for another selector", zones, lists)
+	}
+	if zones, _, _ = z.get("tier=reserved", now.Add(2*nodeLabelRefreshInterval), list("zone-c", "zone-d")); len(zones) != 2 || lists != 3 {
+		t.Errorf("zones = %v after %d lists, want a list once stale", zones, lists)
+	}
+}
+
+func TestMutatingAdmission_Handle_ZoneAwareFloor(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "2",
+		AnnotationHighWaterLevel:       "5",
+		AnnotationZoneAwareFloor:       "true",
+	})
+	// The workload reached its low water level, but in a single zone.
+	existing := []runtime.Object{deploy, rs, newZonedNode("node-a", OnDemandValue, "zone-a"), newZonedNode("node-b", OnDemandValue, "zone-b")}
+	for i := 0; i < 2; i++ {
+		p := newOnDemandPod(pod, fmt.Sprintf("existing-%d", i))
+		p.Spec.NodeName = "node-a"
+		existing = append(existing, p)
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	m := &MutatingAdmission{Decoder: &fakeMutationDecoder{obj: pod}, Client: fake.NewSimpleClientset(existing...)}
+	got := m.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !got.Allowed {
+		t.Fatalf("Handle() got.Allowed = false: %v", got.Result)
+	}
+	if tier := placedTier(t, got); tier != OnDemandValue {
+		t.Errorf("Handle() placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	if reason, want := got.AuditAnnotations[audit.AnnotationReason], "on-demand by below-zone-floor in zone-b"; reason != want {
+		t.Errorf("Handle() audit reason = %q, want %q", reason, want)
+	}
+	patches, _ := json.Marshal(got.Patches)
+	if !strings.Contains(string(patches), `"zone-b"`) {
+		t.Errorf("Handle() patches = %s, want the zone-b affinity", patches)
+	}
+}
+
+func TestMutatingAdmission_Handle_ZoneAwareFloorBreakerOpen(t *testing.T) {
+	deploy, rs, pod := newWorkloadObjects(map[string]string{
+		AnnotationScheduleCompensation: "true",
+		AnnotationLowWaterLevel:        "4",
+		AnnotationHighWaterLevel:       "8",
+		AnnotationZoneAwareFloor:       "true",
+	})
+	existing := []runtime.Object{deploy, rs, newZonedNode("node-a", OnDemandValue, "zone-a"), newZonedNode("node-b", OnDemandValue, "zone-b")}
+	for i := 0; i < 3; i++ {
+		p := newOnDemandPod(pod, fmt.Sprintf("existing-%d", i))
+		p.Spec.NodeName = "node-a"
+		existing = append(existing, p)
+	}
+	client := fake.NewSimpleClientset(existing...)
+	m := &MutatingAdmission{
+		Decoder: &fakeMutationDecoder{obj: pod},
+		Client:  client,
+		Breaker: NewAPIServerBreaker(circuitbreaker.Options{
+			Window:               time.Minute,
+			MinRequests:          1,
+			FailureRateThreshold: 0.1,
+			OpenDuration:         time.Hour,
+		}),
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	// The counted admission reaches the low water level of the workload.
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != OnDemandValue {
+		t.Fatalf("counted admission placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	client.PrependReactor("get", "replicasets", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("overloaded")
+	})
+	if got := m.Handle(context.Background(), req); got.Allowed {
+		t.Fatalf("Handle() allowed the request that tripped the breaker")
+	}
+
+	// While open, the cached placements still fill the floor of zone-b.
+	got := m.Handle(context.Background(), req)
+	if tier := placedTier(t, got); tier != OnDemandValue {
+		t.Errorf("cached admission placed pod on %q, want %q", tier, OnDemandValue)
+	}
+	if reason, want := got.AuditAnnotations[audit.AnnotationReason], "on-demand by below-zone-floor in zone-b"; !strings.HasPrefix(reason, want) {
+		t.Errorf("cached admission audit reason = %q, want %q", reason, want)
+	}
+	patches, _ := json.Marshal(got.Patches)
+	if !strings.Contains(string(patches), `"zone-b"`) {
+		t.Errorf("cached admission patches = %s, want the zone-b affinity", patches)
+	}
+
+	// Once both zones reached their floor, the next pods go to spot.
+	if tier := placedTier(t, m.Handle(context.Background(), req)); tier != SpotValue {
+		t.Errorf("cached admission placed pod on %q, want %q", tier, SpotValue)
+	}
+}
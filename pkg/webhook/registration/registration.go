@@ -0,0 +1,242 @@
+// Package registration keeps the MutatingWebhookConfiguration and
+// ValidatingWebhookConfiguration of the webhook in line with the handlers it
+// serves, using server-side apply.
+package registration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	admissionregistrationv1ac "k8s.io/client-go/applyconfigurations/admissionregistration/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// FieldManager is the field manager of the applied configurations.
+const FieldManager = "k8s-webhook"
+
+const (
+	// FailurePolicyFail rejects the request when the webhook cannot be called.
+	FailurePolicyFail = string(admissionregistrationv1.Fail)
+	// FailurePolicyIgnore admits the request when the webhook cannot be called.
+	FailurePolicyIgnore = string(admissionregistrationv1.Ignore)
+)
+
+// Options configures a Registrar.
+type Options struct {
+	// ConfigurationName is the name of both webhook configurations.
+	ConfigurationName string
+	// ServiceNamespace, ServiceName and ServicePort locate the Service the API
+	// server calls the webhook through.
+	ServiceNamespace string
+	ServiceName      string
+	ServicePort      int32
+	// URL is the base URL the API server calls the webhook at, instead of the
+	// Service, e.g. https://192.168.254.2:8443 for a webhook run out of cluster.
+	URL string
+	// FailurePolicy is the failure policy of every webhook: Fail or Ignore.
+	FailurePolicy string
+	// TimeoutSeconds is the time the API server waits for the webhook.
+	TimeoutSeconds int32
+	// CABundleFile is the PEM file injected as caBundle when the certificates
+	// are not self-signed. Defaults to the serving certificate.
+	CABundleFile string
+	// SyncInterval is the time between two applies of the configurations.
+	SyncInterval time.Duration
+}
+
+// Webhook describes one webhook of a configuration.
+type Webhook struct {
+	// Name is the fully qualified name of the webhook.
+	Name string
+	// Path is the path the handler is registered at.
+	Path string
+	// Mutating puts the webhook in the MutatingWebhookConfiguration.
+	Mutating bool
+	// Rules are the operations and resources the webhook is called for.
+	Rules []admissionregistrationv1.RuleWithOperations
+	// ObjectSelector restricts the objects the webhook is called for.
+	ObjectSelector *metav1.LabelSelector
+}
+
+// Registrar applies the webhook configurations of the handlers registered
+// through it, on the elected leader, and keeps applying them so that edits by
+// other managers are reverted.
+type Registrar struct {
+	Client  kubernetes.Interface
+	Options Options
+	// CABundle returns the PEM bundle injected into every webhook.
+	CABundle func(ctx context.Context) ([]byte, error)
+
+	mu       sync.Mutex
+	webhooks []Webhook
+}
+
+var _ manager.LeaderElectionRunnable = &Registrar{}
+
+// Register registers handler at hook.Path of server and adds hook to the
+// applied configurations.
+func (r *Registrar) Register(server webhook.Server, hook Webhook, handler http.Handler) {
+	server.Register(hook.Path, handler)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.webhooks = append(r.webhooks, hook)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *Registrar) NeedLeaderElection() bool {
+	return true
+}
+
+// Start applies the configurations every SyncInterval until ctx is done.
+func (r *Registrar) Start(ctx context.Context) error {
+	klog.Infof("Starting webhook configuration registrar for %s with interval %s", r.Options.ConfigurationName, r.Options.SyncInterval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := r.Apply(ctx); err != nil {
+			klog.Errorf("Failed to apply webhook configurations: %v", err)
+		}
+	}, r.Options.SyncInterval)
+	return nil
+}
+
+// Apply applies the MutatingWebhookConfiguration and the
+// ValidatingWebhookConfiguration of the registered webhooks. A configuration
+// without webhooks is not applied.
+func (r *Registrar) Apply(ctx context.Context) error {
+	caBundle, err := r.CABundle(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load the CA bundle: %w", err)
+	}
+	mutating, validating := r.configurations(caBundle)
+	opts := metav1.ApplyOptions{FieldManager: FieldManager, Force: true}
+	client := r.Client.AdmissionregistrationV1()
+	if len(mutating.Webhooks) > 0 {
+		if _, err := client.MutatingWebhookConfigurations().Apply(ctx, mutating, opts); err != nil {
+			return fmt.Errorf("failed to apply MutatingWebhookConfiguration %s: %w", r.Options.ConfigurationName, err)
+		}
+	}
+	if len(validating.Webhooks) > 0 {
+		if _, err := client.ValidatingWebhookConfigurations().Apply(ctx, validating, opts); err != nil {
+			return fmt.Errorf("failed to apply ValidatingWebhookConfiguration %s: %w", r.Options.ConfigurationName, err)
+		}
+	}
+	klog.V(4).Infof("Applied webhook configurations %s", r.Options.ConfigurationName)
+	return nil
+}
+
+// Delete deletes both webhook configurations. Missing ones are ignored.
+func (r *Registrar) Delete(ctx context.Context) error {
+	client := r.Client.AdmissionregistrationV1()
+	err := client.MutatingWebhookConfigurations().Delete(ctx, r.Options.ConfigurationName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete MutatingWebhookConfiguration %s: %w", r.Options.ConfigurationName, err)
+	}
+	err = client.ValidatingWebhookConfigurations().Delete(ctx, r.Options.ConfigurationName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ValidatingWebhookConfiguration %s: %w", r.Options.ConfigurationName, err)
+	}
+	return nil
+}
+
+func (r *Registrar) configurations(caBundle []byte) (*admissionregistrationv1ac.MutatingWebhookConfigurationApplyConfiguration, *admissionregistrationv1ac.ValidatingWebhookConfigurationApplyConfiguration) {
+	labels := map[string]string{
+		"app":                          r.Options.ServiceName,
+		"app.kubernetes.io/managed-by": FieldManager,
+	}
+	mutating := admissionregistrationv1ac.MutatingWebhookConfiguration(r.Options.ConfigurationName).WithLabels(labels)
+	validating := admissionregistrationv1ac.ValidatingWebhookConfiguration(r.Options.ConfigurationName).WithLabels(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, hook := range r.webhooks {
+		clientConfig := r.clientConfig(hook.Path, caBundle)
+		rules := applyRules(hook.Rules)
+		if hook.Mutating {
+			wh := admissionregistrationv1ac.MutatingWebhook().
+				WithName(hook.Name).
+				WithAdmissionReviewVersions("v1").
+				WithSideEffects(admissionregistrationv1.SideEffectClassNone).
+				WithFailurePolicy(admissionregistrationv1.FailurePolicyType(r.Options.FailurePolicy)).
+				WithTimeoutSeconds(r.Options.TimeoutSeconds).
+				WithClientConfig(clientConfig).
+				WithRules(rules...)
+			if hook.ObjectSelector != nil {
+				wh.WithObjectSelector(applySelector(hook.ObjectSelector))
+			}
+			mutating.WithWebhooks(wh)
+			continue
+		}
+		wh := admissionregistrationv1ac.ValidatingWebhook().
+			WithName(hook.Name).
+			WithAdmissionReviewVersions("v1").
+			WithSideEffects(admissionregistrationv1.SideEffectClassNone).
+			WithFailurePolicy(admissionregistrationv1.FailurePolicyType(r.Options.FailurePolicy)).
+			WithTimeoutSeconds(r.Options.TimeoutSeconds).
+			WithClientConfig(clientConfig).
+			WithRules(rules...)
+		if hook.ObjectSelector != nil {
+			wh.WithObjectSelector(applySelector(hook.ObjectSelector))
+		}
+		validating.WithWebhooks(wh)
+	}
+	return mutating, validating
+}
+
+func (r *Registrar) clientConfig(path string, caBundle []byte) *admissionregistrationv1ac.WebhookClientConfigApplyConfiguration {
+	config := admissionregistrationv1ac.WebhookClientConfig().WithCABundle(caBundle...)
+	if r.Options.URL != "" {
+		return config.WithURL(strings.TrimSuffix(r.Options.URL, "/") + path)
+	}
+	return config.WithService(admissionregistrationv1ac.ServiceReference().
+		WithNamespace(r.Options.ServiceNamespace).
+		WithName(r.Options.ServiceName).
+		WithPort(r.Options.ServicePort).
+		WithPath(path))
+}
+
+func applyRules(rules []admissionregistrationv1.RuleWithOperations) []*admissionregistrationv1ac.RuleWithOperationsApplyConfiguration {
+	out := make([]*admissionregistrationv1ac.RuleWithOperationsApplyConfiguration, 0, len(rules))
+	for _, rule := range rules {
+		ac := admissionregistrationv1ac.RuleWithOperations().
+			WithOperations(rule.Operations...).
+			WithAPIGroups(rule.APIGroups...).
+			WithAPIVersions(rule.APIVersions...).
+			WithResources(rule.Resources...)
+		if rule.Scope != nil {
+			ac.WithScope(*rule.Scope)
+		}
+		out = append(out, ac)
+	}
+	return out
+}
+
+func applySelector(selector *metav1.LabelSelector) *metav1ac.LabelSelectorApplyConfiguration {
+	ac := metav1ac.LabelSelector().WithMatchLabels(selector.MatchLabels)
+	for _, req := range selector.MatchExpressions {
+		ac.WithMatchExpressions(metav1ac.LabelSelectorRequirement().
+			WithKey(req.Key).
+			WithOperator(req.Operator).
+			WithValues(req.Values...))
+	}
+	return ac
+}
+
+// FileCABundle returns a CABundle func reading path on every call, so that a
+// renewed file is picked up.
+func FileCABundle(path string) func(context.Context) ([]byte, error) {
+	return func(context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
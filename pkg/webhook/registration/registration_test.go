@@ -0,0 +1,138 @@
+package registration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+var podCreate = []admissionregistrationv1.RuleWithOperations{{
+	Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+	Rule: admissionregistrationv1.Rule{
+		APIGroups:   []string{""},
+		APIVersions: []string{"v1"},
+		Resources:   []string{"pods"},
+	},
+}}
+
+func newTestRegistrar(client *fake.Clientset, caBundle *[]byte) *Registrar {
+	return &Registrar{
+		Client: client,
+		Options: Options{
+			ConfigurationName: "k8s-webhook-template",
+			ServiceNamespace:  "k8s-webhook-template",
+			ServiceName:       "k8s-webhook-template",
+			ServicePort:       443,
+			FailurePolicy:     FailurePolicyFail,
+			TimeoutSeconds:    10,
+		},
+		CABundle: func(context.Context) ([]byte, error) { return *caBundle, nil },
+	}
+}
+
+func TestRegistrar_Apply(t *testing.T) {
+	client := fake.NewClientset()
+	caBundle := []byte("ca-1")
+	r := newTestRegistrar(client, &caBundle)
+	server := webhook.NewServer(webhook.Options{})
+	r.Register(server, Webhook{
+		Name:     "mutate-pod.webhook-demo.com",
+		Path:     "/mutate-pod",
+		Mutating: true,
+		Rules:    podCreate,
+		ObjectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"k8s-webhook-template"},
+		}}},
+	}, http.NotFoundHandler())
+	r.Register(server, Webhook{Name: "validate-pod.webhook-demo.com", Path: "/validate-pod", Rules: podCreate}, http.NotFoundHandler())
+
+	if err := r.Apply(context.TODO()); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get MutatingWebhookConfiguration: %v", err)
+	}
+	if len(mutating.Webhooks) != 1 {
+		t.Fatalf("got %d mutating webhooks, want 1", len(mutating.Webhooks))
+	}
+	wh := mutating.Webhooks[0]
+	if svc := wh.ClientConfig.Service; svc == nil || svc.Name != "k8s-webhook-template" || *svc.Path != "/mutate-pod" || *svc.Port != 443 {
+		t.Errorf("service = %+v, want k8s-webhook-template:443/mutate-pod", svc)
+	}
+	if string(wh.ClientConfig.CABundle) != "ca-1" {
+		t.Errorf("caBundle = %q, want ca-1", wh.ClientConfig.CABundle)
+	}
+	if *wh.FailurePolicy != admissionregistrationv1.Fail || *wh.SideEffects != admissionregistrationv1.SideEffectClassNone {
+		t.Errorf("failurePolicy = %s, sideEffects = %s", *wh.FailurePolicy, *wh.SideEffects)
+	}
+	if wh.ObjectSelector == nil || len(wh.ObjectSelector.MatchExpressions) != 1 {
+		t.Errorf("objectSelector = %+v, want the webhook pods excluded", wh.ObjectSelector)
+	}
+	validating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get ValidatingWebhookConfiguration: %v", err)
+	}
+	if len(validating.Webhooks) != 1 || *validating.Webhooks[0].ClientConfig.Service.Path != "/validate-pod" {
+		t.Errorf("validating webhooks = %+v, want /validate-pod", validating.Webhooks)
+	}
+
+	// A renewed CA is injected by the next apply.
+	caBundle = []byte("ca-2")
+	if err := r.Apply(context.TODO()); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	mutating, _ = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if string(mutating.Webhooks[0].ClientConfig.CABundle) != "ca-2" {
+		t.Errorf("caBundle = %q, want ca-2", mutating.Webhooks[0].ClientConfig.CABundle)
+	}
+}
+
+func TestRegistrar_ApplyURL(t *testing.T) {
+	client := fake.NewClientset()
+	caBundle := []byte("ca")
+	r := newTestRegistrar(client, &caBundle)
+	r.Options.URL = "https://192.168.254.2:8443/"
+	r.Register(webhook.NewServer(webhook.Options{}), Webhook{Name: "mutate-pod.webhook-demo.com", Path: "/mutate-pod", Mutating: true, Rules: podCreate}, http.NotFoundHandler())
+
+	if err := r.Apply(context.TODO()); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get MutatingWebhookConfiguration: %v", err)
+	}
+	if url := mutating.Webhooks[0].ClientConfig.URL; url == nil || *url != "https://192.168.254.2:8443/mutate-pod" {
+		t.Errorf("url = %v, want https://192.168.254.2:8443/mutate-pod", url)
+	}
+	// Without validating handlers no ValidatingWebhookConfiguration is applied.
+	_, err = client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("ValidatingWebhookConfiguration get error = %v, want NotFound", err)
+	}
+}
+
+func TestRegistrar_Delete(t *testing.T) {
+	client := fake.NewClientset()
+	caBundle := []byte("ca")
+	r := newTestRegistrar(client, &caBundle)
+	r.Register(webhook.NewServer(webhook.Options{}), Webhook{Name: "mutate-pod.webhook-demo.com", Path: "/mutate-pod", Mutating: true, Rules: podCreate}, http.NotFoundHandler())
+	if err := r.Apply(context.TODO()); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// The missing ValidatingWebhookConfiguration is ignored.
+	if err := r.Delete(context.TODO()); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "k8s-webhook-template", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("MutatingWebhookConfiguration get error = %v, want NotFound", err)
+	}
+}